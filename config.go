@@ -1,7 +1,13 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -16,6 +22,10 @@ type config struct {
 	// Default is "release"
 	Source string `toml:"source"`
 
+	// Version pins release of GitHub source by exact tag like "v8.1.1234", or
+	// range of versions like ">=8.1 <8.2" or "8.1.*".
+	Version string `toml:"version"`
+
 	// TargetDir is target directory to update.  Default is current working
 	// directory.
 	TargetDir string `toml:"target_dir"`
@@ -36,14 +46,125 @@ type config struct {
 	// DownloadTimeout is timeout for downloading archive (default: "5min")
 	DownloadTimeout string `toml:"download_timeout"`
 
+	// RetryCount is max number of retries for transient errors (default: 3).
+	// 0 disables retries.
+	RetryCount *int `toml:"retry_count"`
+
+	// RetryWait is initial wait before a retry, which doubles at each retry
+	// (default: "1s").
+	RetryWait string `toml:"retry_wait"`
+
+	// LockTimeout is timeout to wait for other netupvim running on same
+	// target (default: "1m").  "0" fails immediately.
+	LockTimeout string `toml:"lock_timeout"`
+
+	// ArchiveMaxEntries is max number of files in an archive (default:
+	// 100000).  0 disables the limit.
+	ArchiveMaxEntries *int `toml:"archive_max_entries"`
+
+	// ArchiveMaxSize is max total size of files in an archive when extracted,
+	// like "512MB" (default: "4GB").  "0" disables the limit.
+	ArchiveMaxSize string `toml:"archive_max_size"`
+
+	// ArchiveMaxRatio is max ratio of extracted size to size of an archive
+	// (default: 100).  0 disables the limit.
+	ArchiveMaxRatio *int `toml:"archive_max_ratio"`
+
 	// LogRotateCount is used for log rotation.
 	LogRotateCount int `toml:"log_rotate_count"`
 
 	// ExeRotateCount is used for executable files rotation.
 	ExeRotateCount int `toml:"exe_rotate_count"`
 
+	// HistoryCount is number of generations which be kept for rollback.
+	HistoryCount int `toml:"history_count"`
+
+	// ExtractWorkers is number of workers to extract files concurrently.
+	ExtractWorkers int `toml:"extract_workers"`
+
 	// DisableSelfUpdate disables netupvim's self update.
 	DisableSelfUpdate bool `toml:"disable_self_update"`
+
+	// Mirror is URL of a mirror server ("netupvim serve").  All archives are
+	// downloaded from it when it is set.
+	Mirror string `toml:"mirror"`
+
+	// Sources defines named sources, which can be selected by Source.
+	Sources map[string]*sourceConfig `toml:"sources"`
+
+	// Packages lists packages to update in a run.  Default is Vim from
+	// Source only.
+	Packages []*packageConfig `toml:"packages"`
+}
+
+// packageConfig is a package to update in a run.
+type packageConfig struct {
+	// Source is name of source.  Default is Source (or "-s" option).
+	Source string `toml:"source"`
+
+	// Dir is directory to install, relative to target dir.  Default is
+	// target dir.
+	Dir string `toml:"dir"`
+
+	// Hint is a file to detect CPU, relative to target dir.  Default is
+	// "vim.exe".
+	Hint string `toml:"hint"`
+}
+
+// sourceConfig is an user-defined source.  Properties for a CPU can be
+// overridden by X86 or AMD64 sub table.
+type sourceConfig struct {
+	// Type is type of source: "github", "direct" or "file".
+	Type string `toml:"type"`
+
+	// Name is name of package, which is used for work dir.  Default is
+	// "vim".
+	Name string `toml:"name"`
+
+	// User and Project are GitHub's user and project (for "github").
+	User    string `toml:"user"`
+	Project string `toml:"project"`
+
+	// NamePattern is regexp of asset's name (for "github"), or archive's
+	// name in Path directory (for "file").
+	NamePattern string `toml:"name_pattern"`
+
+	// Version pins release (for "github").
+	Version string `toml:"version"`
+
+	// PreRelease follows pre-releases too (for "github").
+	PreRelease *bool `toml:"prerelease"`
+
+	// APIURL is base URL of GitHub API for GitHub Enterprise, like
+	// "https://ghe.example.com/api/v3" (for "github").
+	APIURL string `toml:"api_url"`
+
+	// Token is a token for the API of this source (for "github").  Global
+	// github_token is used for api.github.com only.
+	Token string `toml:"token"`
+
+	// URL is URL of archive (for "direct").
+	URL string `toml:"url"`
+
+	// Path is path of an archive, or a directory which contains archives
+	// (for "file").  UNC path like "\\server\share\vim" is available.
+	Path string `toml:"path"`
+
+	// Strip is number of leading path elements to strip from archive.
+	Strip *int `toml:"strip"`
+
+	// SHA256 is expected SHA-256 of archive.
+	SHA256 string `toml:"sha256"`
+
+	// SHA256URL is URL of checksum file (for "direct").
+	SHA256URL string `toml:"sha256_url"`
+
+	// SHA256Pattern is regexp of checksum asset's name (for "github"), or
+	// checksum file's name in same directory (for "file").
+	SHA256Pattern string `toml:"sha256_pattern"`
+
+	X86   *sourceConfig `toml:"x86"`
+	AMD64 *sourceConfig `toml:"amd64"`
 }
 
 func loadConfig(name string) (*config, error) {
@@ -76,6 +197,217 @@ func (c *config) getTargetDir() string {
 	return dir
 }
 
+// getSources returns built-in and user-defined sources.  User-defined ones
+// override built-in ones which have same names.
+func (c *config) getSources() (map[string]netup.SourcePack, error) {
+	packs := make(map[string]netup.SourcePack, len(vimSet)+len(c.Sources))
+	for k, v := range vimSet {
+		packs[k] = v
+	}
+	for k, v := range c.Sources {
+		p, err := v.sourcePack(k)
+		if err != nil {
+			return nil, err
+		}
+		packs[k] = p
+	}
+	return packs, nil
+}
+
+// merge returns a copy of sc which is overridden by non-empty properties of
+// o.
+func (sc sourceConfig) merge(o *sourceConfig) sourceConfig {
+	if o == nil {
+		return sc
+	}
+	for _, p := range []struct{ dst, src *string }{
+		{&sc.Type, &o.Type},
+		{&sc.Name, &o.Name},
+		{&sc.User, &o.User},
+		{&sc.Project, &o.Project},
+		{&sc.NamePattern, &o.NamePattern},
+		{&sc.Version, &o.Version},
+		{&sc.APIURL, &o.APIURL},
+		{&sc.Token, &o.Token},
+		{&sc.URL, &o.URL},
+		{&sc.Path, &o.Path},
+		{&sc.SHA256, &o.SHA256},
+		{&sc.SHA256URL, &o.SHA256URL},
+		{&sc.SHA256Pattern, &o.SHA256Pattern},
+	} {
+		if *p.src != "" {
+			*p.dst = *p.src
+		}
+	}
+	if o.Strip != nil {
+		sc.Strip = o.Strip
+	}
+	if o.PreRelease != nil {
+		sc.PreRelease = o.PreRelease
+	}
+	return sc
+}
+
+func (sc *sourceConfig) sourcePack(name string) (netup.SourcePack, error) {
+	variants := map[arch.CPU]*sourceConfig{
+		arch.X86:   sc.X86,
+		arch.AMD64: sc.AMD64,
+	}
+	if sc.X86 == nil && sc.AMD64 == nil {
+		variants = map[arch.CPU]*sourceConfig{
+			arch.X86:   sc,
+			arch.AMD64: sc,
+		}
+	}
+	pack := netup.SourcePack{}
+	for cpu, v := range variants {
+		if v == nil {
+			continue
+		}
+		src, err := sc.merge(v).source()
+		if err != nil {
+			return nil, fmt.Errorf("source %q (%s): %s", name, cpuName(cpu), err)
+		}
+		pack[cpu] = src
+	}
+	return pack, nil
+}
+
+func cpuName(cpu arch.CPU) string {
+	switch cpu {
+	case arch.X86:
+		return "x86"
+	case arch.AMD64:
+		return "amd64"
+	}
+	return "unknown"
+}
+
+// source validates properties and creates a source.
+func (sc sourceConfig) source() (netup.Source, error) {
+	name := sc.Name
+	if name == "" {
+		name = "vim"
+	}
+	strip := 0
+	if sc.Strip != nil {
+		strip = *sc.Strip
+	}
+	if strip < 0 {
+		return nil, fmt.Errorf("strip should not be negative: %d", strip)
+	}
+	if sc.SHA256 != "" {
+		if err := netup.ValidateSHA256(sc.SHA256); err != nil {
+			return nil, err
+		}
+	}
+	switch sc.Type {
+	case "github":
+		if sc.User == "" || sc.Project == "" {
+			return nil, errors.New("user and project are required for github")
+		}
+		if sc.NamePattern == "" {
+			return nil, errors.New("name_pattern is required for github")
+		}
+		pat, err := regexp.Compile(sc.NamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name_pattern: %s", err)
+		}
+		var sumPat *regexp.Regexp
+		if sc.SHA256Pattern != "" {
+			sumPat, err = regexp.Compile(sc.SHA256Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sha256_pattern: %s", err)
+			}
+		}
+		if sc.Version != "" {
+			if err := netup.ValidateVersion(sc.Version); err != nil {
+				return nil, err
+			}
+		}
+		if sc.APIURL != "" {
+			if err := validateURL("api_url", sc.APIURL); err != nil {
+				return nil, err
+			}
+		}
+		return &netup.GithubSource{
+			Name:       name,
+			User:       sc.User,
+			Project:    sc.Project,
+			NamePat:    pat,
+			Strip:      strip,
+			SHA256:     sc.SHA256,
+			SHA256Pat:  sumPat,
+			Version:    sc.Version,
+			APIURL:     sc.APIURL,
+			Token:      sc.Token,
+			PreRelease: sc.PreRelease != nil && *sc.PreRelease,
+		}, nil
+	case "direct":
+		if err := validateURL("url", sc.URL); err != nil {
+			return nil, err
+		}
+		if sc.SHA256URL != "" {
+			if err := validateURL("sha256_url", sc.SHA256URL); err != nil {
+				return nil, err
+			}
+		}
+		return &netup.DirectSource{
+			Name:      name,
+			URL:       sc.URL,
+			Strip:     strip,
+			SHA256:    sc.SHA256,
+			SHA256URL: sc.SHA256URL,
+		}, nil
+	case "file":
+		if sc.Path == "" {
+			return nil, errors.New("path is required for file")
+		}
+		var (
+			pat, sumPat *regexp.Regexp
+			err         error
+		)
+		if sc.NamePattern != "" {
+			pat, err = regexp.Compile(sc.NamePattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid name_pattern: %s", err)
+			}
+		}
+		if sc.SHA256Pattern != "" {
+			sumPat, err = regexp.Compile(sc.SHA256Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sha256_pattern: %s", err)
+			}
+		}
+		return &netup.FileSource{
+			Name:      name,
+			Path:      sc.Path,
+			NamePat:   pat,
+			Strip:     strip,
+			SHA256:    sc.SHA256,
+			SHA256Pat: sumPat,
+		}, nil
+	case "":
+		return nil, errors.New("type is required: github, direct or file")
+	default:
+		return nil, fmt.Errorf("unknown type: %q", sc.Type)
+	}
+}
+
+func validateURL(key, s string) error {
+	if s == "" {
+		return fmt.Errorf("%s is required", key)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", key, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid %s: unsupported scheme %q", key, u.Scheme)
+	}
+	return nil
+}
+
 func (c *config) getCPU() arch.CPU {
 	return arch.ParseCPU(c.CPU)
 }
@@ -96,6 +428,28 @@ func (c *config) getGithubToken() string {
 	return v
 }
 
+func (c *config) getRetryWait() time.Duration {
+	if c.RetryWait == "" {
+		return time.Second
+	}
+	t, err := time.ParseDuration(c.RetryWait)
+	if err != nil {
+		netup.LogFatal(err)
+	}
+	return t
+}
+
+func (c *config) getLockTimeout() time.Duration {
+	if c.LockTimeout == "" {
+		return time.Minute
+	}
+	t, err := time.ParseDuration(c.LockTimeout)
+	if err != nil {
+		netup.LogFatal(err)
+	}
+	return t
+}
+
 func (c *config) getDownloadTimeout() time.Duration {
 	if c.DownloadTimeout == "" {
 		return 5 * time.Minute
@@ -106,3 +460,37 @@ func (c *config) getDownloadTimeout() time.Duration {
 	}
 	return t
 }
+
+var sizeRx = regexp.MustCompile(`^(\d+)\s*([KMG]?)B?$`)
+
+// parseSize parses size in bytes with an optional unit: "KB", "MB" or "GB".
+func parseSize(s string) (int64, error) {
+	m := sizeRx.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	switch m[2] {
+	case "K":
+		n <<= 10
+	case "M":
+		n <<= 20
+	case "G":
+		n <<= 30
+	}
+	return n, nil
+}
+
+func (c *config) getArchiveMaxSize() int64 {
+	if c.ArchiveMaxSize == "" {
+		return 4 << 30
+	}
+	n, err := parseSize(c.ArchiveMaxSize)
+	if err != nil {
+		netup.LogFatal(err)
+	}
+	return n
+}
@@ -1,6 +1,13 @@
 package main
 
-import "testing"
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koron/go-arch"
+	"github.com/koron/netupvim/netup"
+)
 
 func TestLoadConfigEmpty(t *testing.T) {
 	c, err := loadConfig("test_data/not_exist.ini")
@@ -87,6 +94,52 @@ func TestLoadTimeout(t *testing.T) {
 	if c.DownloadTimeout != "1200s" {
 		t.Errorf("c.DownloadTimeout should be %q: %q", "1200s", c.DownloadTimeout)
 	}
+	if d := c.getLockTimeout(); d != 0 {
+		t.Errorf("c.getLockTimeout() should be 0: %s", d)
+	}
+	if c.RetryCount == nil || *c.RetryCount != 0 {
+		t.Errorf("c.RetryCount should be 0: %v", c.RetryCount)
+	}
+	if d := c.getRetryWait(); d != 500*time.Millisecond {
+		t.Errorf("c.getRetryWait() should be 500ms: %s", d)
+	}
+}
+
+func TestLoadArchiveLimits(t *testing.T) {
+	c, err := loadConfig("test_data/archive.ini")
+	if err != nil {
+		t.Fatalf("loadConfig(archive) should be succeeded: %s", err)
+	}
+	if c.ArchiveMaxEntries == nil || *c.ArchiveMaxEntries != 0 {
+		t.Errorf("c.ArchiveMaxEntries should be 0: %v", c.ArchiveMaxEntries)
+	}
+	if n := c.getArchiveMaxSize(); n != 512<<20 {
+		t.Errorf("c.getArchiveMaxSize() should be 512MB: %d", n)
+	}
+	if c.ArchiveMaxRatio == nil || *c.ArchiveMaxRatio != 20 {
+		t.Errorf("c.ArchiveMaxRatio should be 20: %v", c.ArchiveMaxRatio)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"0":      0,
+		"1024":   1024,
+		"16KB":   16 << 10,
+		"512mb":  512 << 20,
+		"4G":     4 << 30,
+		" 2 GB ": 2 << 30,
+	} {
+		n, err := parseSize(s)
+		if err != nil || n != want {
+			t.Errorf("parseSize(%q) should be %d: %d %v", s, want, n, err)
+		}
+	}
+	for _, s := range []string{"", "-1", "1TB", "MB", "1.5GB"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) should fail", s)
+		}
+	}
 }
 
 func TestLoadConfigAll(t *testing.T) {
@@ -109,4 +162,186 @@ func TestLoadConfigAll(t *testing.T) {
 	if c.ExeRotateCount != 5678 {
 		t.Errorf("c.ExeRotateCount is unexpected: %d", c.ExeRotateCount)
 	}
+	if c.Version != "8.1.*" {
+		t.Errorf("c.Version is unexpected: %q", c.Version)
+	}
+	if c.HistoryCount != 9 {
+		t.Errorf("c.HistoryCount is unexpected: %d", c.HistoryCount)
+	}
+}
+
+func TestLoadSources(t *testing.T) {
+	c, err := loadConfig("test_data/sources.ini")
+	if err != nil {
+		t.Fatalf("loadConfig(sources) should be succeeded: %s", err)
+	}
+	packs, err := c.getSources()
+	if err != nil {
+		t.Fatalf("getSources() should be succeeded: %s", err)
+	}
+	if _, ok := packs["release"]; !ok {
+		t.Errorf("built-in source should be kept")
+	}
+
+	p, ok := packs["mybuild"]
+	if !ok {
+		t.Fatalf("user-defined source \"mybuild\" not found")
+	}
+	ds, ok := p[arch.X86].(*netup.DirectSource)
+	if !ok {
+		t.Fatalf("mybuild x86 should be DirectSource: %#v", p[arch.X86])
+	}
+	if ds.URL != "https://build.example.com/vim-win32.zip" || ds.Strip != 1 || ds.Name != "vim" {
+		t.Errorf("unexpected mybuild x86: %+v", ds)
+	}
+	ds, ok = p[arch.AMD64].(*netup.DirectSource)
+	if !ok {
+		t.Fatalf("mybuild amd64 should be DirectSource: %#v", p[arch.AMD64])
+	}
+	if ds.SHA256URL != "https://build.example.com/vim-win64.zip.sha256" {
+		t.Errorf("unexpected mybuild amd64: %+v", ds)
+	}
+
+	p, ok = packs["fork"]
+	if !ok {
+		t.Fatalf("user-defined source \"fork\" not found")
+	}
+	for _, cpu := range []arch.CPU{arch.X86, arch.AMD64} {
+		gs, ok := p[cpu].(*netup.GithubSource)
+		if !ok {
+			t.Fatalf("fork should be GithubSource: %#v", p[cpu])
+		}
+		if gs.User != "foo" || gs.Project != "vim-kaoriya" || gs.Version != "8.1.*" || !gs.PreRelease {
+			t.Errorf("unexpected fork: %+v", gs)
+		}
+		if !gs.NamePat.MatchString("vim81-kaoriya-win64-8.1.1234.zip") {
+			t.Errorf("unexpected fork pattern: %s", gs.NamePat)
+		}
+		if gs.SHA256Pat == nil {
+			t.Errorf("fork should have SHA256Pat")
+		}
+	}
+
+	p, ok = packs["ghe"]
+	if !ok {
+		t.Fatalf("user-defined source \"ghe\" not found")
+	}
+	gs, ok := p[arch.AMD64].(*netup.GithubSource)
+	if !ok {
+		t.Fatalf("ghe should be GithubSource: %#v", p[arch.AMD64])
+	}
+	if gs.APIURL != "https://ghe.example.com/api/v3" || gs.Token != "fedcba9876543210" || gs.PreRelease {
+		t.Errorf("unexpected ghe: %+v", gs)
+	}
+
+	p, ok = packs["share"]
+	if !ok {
+		t.Fatalf("user-defined source \"share\" not found")
+	}
+	fs, ok := p[arch.AMD64].(*netup.FileSource)
+	if !ok {
+		t.Fatalf("share should be FileSource: %#v", p[arch.AMD64])
+	}
+	if fs.Path != `\\fileserver\share\vim` || fs.Strip != 1 || fs.NamePat == nil {
+		t.Errorf("unexpected share: %+v", fs)
+	}
+}
+
+func TestLoadSourcesInvalid(t *testing.T) {
+	c, err := loadConfig("test_data/sources_invalid.ini")
+	if err != nil {
+		t.Fatalf("loadConfig(sources_invalid) should be succeeded: %s", err)
+	}
+	if _, err := c.getSources(); err == nil {
+		t.Errorf("getSources() should fail for invalid name_pattern")
+	}
+
+	for _, sc := range []sourceConfig{
+		{},
+		{Type: "ftp"},
+		{Type: "github", User: "foo"},
+		{Type: "github", User: "foo", Project: "bar"},
+		{Type: "github", User: "foo", Project: "bar", NamePattern: "x", APIURL: "ghe.example.com"},
+		{Type: "direct"},
+		{Type: "direct", URL: "file:///tmp/vim.zip"},
+		{Type: "direct", URL: "https://example.com/vim.zip", SHA256: "xyz"},
+		{Type: "file"},
+		{Type: "file", Path: "vim.zip", NamePattern: "("},
+	} {
+		if _, err := sc.source(); err == nil {
+			t.Errorf("source() should fail: %+v", sc)
+		}
+	}
+}
+
+func TestLoadPackages(t *testing.T) {
+	c, err := loadConfig("test_data/packages.ini")
+	if err != nil {
+		t.Fatalf("loadConfig(packages) should be succeeded: %s", err)
+	}
+	if len(c.Packages) != 3 {
+		t.Fatalf("c.Packages should have 3 items: %d", len(c.Packages))
+	}
+	if c.Packages[1].Source != "runtime" || c.Packages[1].Dir != "vimfiles" {
+		t.Errorf("unexpected c.Packages[1]: %+v", c.Packages[1])
+	}
+	if c.Packages[2].Hint != "ctags.exe" {
+		t.Errorf("unexpected c.Packages[2]: %+v", c.Packages[2])
+	}
+
+	sources, err = c.getSources()
+	if err != nil {
+		t.Fatalf("getSources() should be succeeded: %s", err)
+	}
+	packageConfs = c.Packages
+	pkgs, err := resolvePackages()
+	if err != nil {
+		t.Fatalf("resolvePackages() should be succeeded: %s", err)
+	}
+	if len(pkgs) != 3 {
+		t.Fatalf("resolvePackages() should return 3 packages: %d", len(pkgs))
+	}
+	if pkgs[1].pack.Name() != "runtime" {
+		t.Errorf("unexpected name of package: %q", pkgs[1].pack.Name())
+	}
+	// hint is relative to target dir, even for package in sub dir.
+	if h, _ := filepath.Abs("vim.exe"); pkgs[1].arch.Hint != h {
+		t.Errorf("unexpected hint of package: %q", pkgs[1].arch.Hint)
+	}
+
+	// packages with same name.
+	packageConfs = append(c.Packages, &packageConfig{Source: "develop"})
+	if _, err := resolvePackages(); err == nil {
+		t.Errorf("resolvePackages() should fail for duplicated names")
+	}
+	packageConfs = []*packageConfig{{Source: "runtime", Dir: "../outside"}}
+	if _, err := resolvePackages(); err == nil {
+		t.Errorf("resolvePackages() should fail for dir outside target")
+	}
+	packageConfs = nil
+}
+
+func TestCheckModes(t *testing.T) {
+	defer func() {
+		rollback, checkOnly, dryRun, planFile = false, false, false, ""
+	}()
+	for _, tc := range []struct {
+		rollback, check, dryRun bool
+		plan                    string
+		ok                      bool
+	}{
+		{false, false, false, "", true},
+		{true, false, false, "", true},
+		{false, false, true, "plan.txt", true},
+		{false, false, false, "plan.txt", true},
+		{true, true, false, "", false},
+		{false, true, true, "", false},
+		{true, false, false, "plan.txt", false},
+		{false, true, true, "plan.txt", false},
+	} {
+		rollback, checkOnly, dryRun, planFile = tc.rollback, tc.check, tc.dryRun, tc.plan
+		if err := checkModes(); (err == nil) != tc.ok {
+			t.Errorf("checkModes() for %+v returns unexpected: %v", tc, err)
+		}
+	}
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/koron/netupvim/netup"
 )
@@ -13,12 +15,37 @@ var (
 	version = "none"
 )
 
+// exit codes.  exitError is used by netup.LogFatal.
+const (
+	exitOK              = 0
+	exitError           = 1
+	exitUpdateAvailable = 2
+	exitDamaged         = 3
+	exitCancelled       = 130
+)
+
+var exitCode = exitOK
+
 var (
-	targetDir  = "."
-	sourceName = "release"
-	cpu        string
-	restore    bool
-	selfUpdate = true
+	targetDir    = "."
+	sourceName   = "release"
+	cpu          string
+	pinVersion   string
+	sources      map[string]netup.SourcePack
+	packageConfs []*packageConfig
+	restore      bool
+	rollback     bool
+	generation   = 1
+	checkOnly    bool
+	verifyOnly   bool
+	repairFlag   bool
+	uninstall    bool
+	purge        bool
+	dryRun       bool
+	planFile     string
+	selfUpdate   = true
+	selfPack     netup.SourcePack
+	mirrorURL    string
 )
 
 func setup() error {
@@ -29,11 +56,21 @@ func setup() error {
 
 	// Parse options.
 	var (
-		helpOpt    = flag.Bool("h", false, "show this message")
-		targetOpt  = flag.String("t", conf.getTargetDir(), "target dir to upgrade/install")
-		sourceOpt  = flag.String("s", conf.getSource(), "source of update: release,develop,canary")
-		restoreOpt = flag.Bool("restore", false, "force download & extract all files")
-		versionOpt = flag.Bool("version", false, "show version")
+		helpOpt     = flag.Bool("h", false, "show this message")
+		targetOpt   = flag.String("t", conf.getTargetDir(), "target dir to upgrade/install")
+		sourceOpt   = flag.String("s", conf.getSource(), "source of update: release,develop,canary,vim.org or user-defined")
+		restoreOpt  = flag.Bool("restore", false, "force download & extract all files")
+		rollbackOpt = flag.Bool("rollback", false, "rollback to previous installation")
+		genOpt      = flag.Int("generation", 1, "number of generations to rollback")
+		checkOpt    = flag.Bool("check", false, "check update is available (exit code 2) or not (0)")
+		verifyOpt   = flag.Bool("verify", false, "verify installed files (exit code 3 when damaged)")
+		repairOpt   = flag.Bool("repair", false, "re-extract missing or modified files only")
+		uninstOpt   = flag.Bool("uninstall", false, "remove installed files which are not modified")
+		purgeOpt    = flag.Bool("purge", false, "remove modified, evacuated and rotated files too with -uninstall")
+		dryRunOpt   = flag.Bool("dry-run", false, "show changes without writing files")
+		planOpt     = flag.String("plan", "", "file to save plan with -dry-run, or plan to apply")
+		outputOpt   = flag.String("output", "text", "output format: text or json")
+		versionOpt  = flag.Bool("version", false, "show version")
 	)
 	flag.Parse()
 	if *helpOpt {
@@ -45,15 +82,109 @@ func setup() error {
 		os.Exit(1)
 	}
 
+	switch *outputOpt {
+	case "text":
+	case "json":
+		netup.OutputJSON = true
+	default:
+		return fmt.Errorf("invalid output format: %s", *outputOpt)
+	}
+
 	// setup context.
 	targetDir = *targetOpt
 	sourceName = *sourceOpt
 	restore = *restoreOpt
+	rollback = *rollbackOpt
+	generation = *genOpt
+	checkOnly = *checkOpt
+	verifyOnly = *verifyOpt
+	repairFlag = *repairOpt
+	uninstall = *uninstOpt
+	purge = *purgeOpt
+	dryRun = *dryRunOpt
+	planFile = *planOpt
 	cpu = conf.CPU
+	if err := checkModes(); err != nil {
+		return err
+	}
+	if err := applyConfig(conf); err != nil {
+		return err
+	}
+	packageConfs = conf.Packages
 	selfUpdate = !conf.DisableSelfUpdate
+	selfPack = netupPack
+
+	// download all archives from a mirror server.
+	if mirrorURL != "" {
+		for k, v := range sources {
+			sources[k] = netup.MirrorPack(mirrorURL, k, v)
+		}
+		selfPack = netup.MirrorPack(mirrorURL, "netup", netupPack)
+	}
+
+	return nil
+}
+
+// checkModes rejects combinations of options which select an operation.
+// "-plan" with "-dry-run" is a file to save the plan.
+func checkModes() error {
+	var modes []string
+	for _, m := range []struct {
+		name string
+		on   bool
+	}{
+		{"-rollback", rollback},
+		{"-check", checkOnly},
+		{"-verify", verifyOnly},
+		{"-repair", repairFlag},
+		{"-uninstall", uninstall},
+		{"-dry-run", dryRun},
+		{"-plan", planFile != "" && !dryRun},
+	} {
+		if m.on {
+			modes = append(modes, m.name)
+		}
+	}
+	if len(modes) > 1 {
+		return fmt.Errorf("options can't be used together: %s", strings.Join(modes, " "))
+	}
+	return nil
+}
+
+// applyConfig applies properties which are shared with serve mode.
+func applyConfig(conf *config) error {
+	pinVersion = conf.Version
+	if pinVersion != "" {
+		if err := netup.ValidateVersion(pinVersion); err != nil {
+			return err
+		}
+	}
+	var err error
+	sources, err = conf.getSources()
+	if err != nil {
+		return err
+	}
+	mirrorURL = conf.Mirror
+	if mirrorURL != "" {
+		if err := validateURL("mirror", mirrorURL); err != nil {
+			return err
+		}
+	}
 
 	netup.Version = version
 	netup.DownloadTimeout = conf.getDownloadTimeout()
+	netup.LockTimeout = conf.getLockTimeout()
+	netup.RetryWait = conf.getRetryWait()
+	if conf.RetryCount != nil {
+		netup.RetryCount = *conf.RetryCount
+	}
+	netup.ArchiveMaxSize = conf.getArchiveMaxSize()
+	if conf.ArchiveMaxEntries != nil {
+		netup.ArchiveMaxEntries = *conf.ArchiveMaxEntries
+	}
+	if conf.ArchiveMaxRatio != nil {
+		netup.ArchiveMaxRatio = *conf.ArchiveMaxRatio
+	}
 	netup.GithubUser = conf.getGithubUser()
 	netup.GithubToken = conf.getGithubToken()
 	netup.GithubVerbose = conf.GithubVerbose
@@ -63,6 +194,12 @@ func setup() error {
 	if conf.ExeRotateCount > 0 {
 		netup.ExeRotateCount = conf.ExeRotateCount
 	}
+	if conf.HistoryCount > 0 {
+		netup.HistoryCount = conf.HistoryCount
+	}
+	if conf.ExtractWorkers > 0 {
+		netup.ExtractWorkers = conf.ExtractWorkers
+	}
 
 	return nil
 }
@@ -75,32 +212,184 @@ func shouldSelfUpdate() bool {
 	return err == nil
 }
 
-func run() error {
+// pkg is a package to update in a run.
+type pkg struct {
+	source string
+	dir    string
+	pack   netup.SourcePack
+	arch   netup.Arch
+}
+
+// resolvePackages determines packages to update.  Vim from selected source is
+// only one by default.
+func resolvePackages() ([]*pkg, error) {
+	confs := packageConfs
+	if len(confs) == 0 {
+		confs = []*packageConfig{{}}
+	}
+	var (
+		pkgs  []*pkg
+		names = map[string]string{}
+	)
+	for _, pc := range confs {
+		p, err := pc.resolve(sourceName)
+		if err != nil {
+			return nil, err
+		}
+		name := p.pack.Name()
+		if name == "netup" {
+			return nil, fmt.Errorf("package %q uses reserved name %q", p.source, name)
+		}
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("packages %q and %q have same name %q", other, p.source, name)
+		}
+		names[name] = p.source
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+func (pc *packageConfig) resolve(defaultSource string) (*pkg, error) {
+	src := pc.Source
+	if src == "" {
+		src = defaultSource
+	}
+	pack, ok := sources[src]
+	if !ok {
+		return nil, fmt.Errorf("invalid source: %s", src)
+	}
+	// a mirror server pins version instead.
+	if pinVersion != "" && pc.Source == "" && mirrorURL == "" {
+		if err := pinPack(src, pack); err != nil {
+			return nil, err
+		}
+	}
+	dir := targetDir
+	if pc.Dir != "" {
+		d := filepath.Clean(pc.Dir)
+		if filepath.IsAbs(d) || d == ".." || strings.HasPrefix(d, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("dir of package %q should be under target dir: %s", src, pc.Dir)
+		}
+		dir = filepath.Join(targetDir, pc.Dir)
+	}
+	// hint is relative to target dir, not to dir of the package.
+	hint := pc.Hint
+	if hint == "" {
+		hint = "vim.exe"
+	}
+	if !filepath.IsAbs(hint) {
+		hint = filepath.Join(targetDir, hint)
+	}
+	hint, err := filepath.Abs(hint)
+	if err != nil {
+		return nil, err
+	}
+	return &pkg{
+		source: src,
+		dir:    dir,
+		pack:   pack,
+		arch:   netup.Arch{Name: cpu, Hint: hint},
+	}, nil
+}
+
+// pinPack pins version of GitHub sources by pinVersion.
+func pinPack(src string, pack netup.SourcePack) error {
+	for _, s := range pack {
+		gs, ok := s.(*netup.GithubSource)
+		if !ok {
+			return fmt.Errorf("version is supported by GitHub sources only: %s", src)
+		}
+		gs.Version = pinVersion
+	}
+	return nil
+}
+
+// planFileFor returns plan file for a package.  Package's name is appended
+// when there are multiple packages.
+func planFileFor(p *pkg, n int) string {
+	if n <= 1 {
+		return planFile
+	}
+	ext := filepath.Ext(planFile)
+	return strings.TrimSuffix(planFile, ext) + "-" + p.pack.Name() + ext
+}
+
+func runPackage(ctx context.Context, p *pkg, workDir string, n int) error {
+	switch {
+	case rollback:
+		return netup.Rollback(ctx, p.dir, workDir, p.pack, p.arch, generation)
+	case checkOnly:
+		ok, err := netup.Check(ctx, p.dir, workDir, p.pack, p.arch)
+		if err != nil {
+			return err
+		}
+		if ok {
+			exitCode = exitUpdateAvailable
+		}
+		return nil
+	case verifyOnly:
+		ok, err := netup.Verify(ctx, p.dir, workDir, p.pack, p.arch)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			exitCode = exitDamaged
+		}
+		return nil
+	case repairFlag:
+		return netup.Repair(ctx, p.dir, workDir, p.pack, p.arch)
+	case uninstall:
+		return netup.Uninstall(ctx, p.dir, workDir, p.pack, p.arch, purge)
+	case dryRun:
+		return netup.DryRun(ctx, p.dir, workDir, p.pack, p.arch, planFileFor(p, n))
+	case planFile != "":
+		return netup.ApplyPlan(ctx, p.dir, workDir, p.pack, p.arch, planFileFor(p, n))
+	default:
+		return netup.Update(ctx, p.dir, workDir, p.pack, p.arch, restore)
+	}
+}
+
+func run(ctx context.Context) error {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		return runServe(ctx, os.Args[2:])
+	}
 	if err := setup(); err != nil {
 		return err
 	}
 	workDir := filepath.Join(targetDir, "netupvim")
-	// update vim
-	vimPack, ok := vimSet[sourceName]
-	if !ok {
-		return fmt.Errorf("invalid source: %s", sourceName)
-	}
-	err := netup.Update(
-		targetDir,
-		workDir,
-		vimPack,
-		netup.Arch{Name: cpu, Hint: "vim.exe"},
-		restore)
+	pkgs, err := resolvePackages()
 	if err != nil {
 		return err
 	}
+	// lock once, not to let other runs get in between packages.
+	unlock, err := netup.LockWorkDir(ctx, workDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, p := range pkgs {
+		if len(pkgs) > 1 {
+			netup.LogNotice("package %s", p.source)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := runPackage(ctx, p, workDir, len(pkgs)); err != nil {
+			return err
+		}
+	}
+	if rollback || checkOnly || verifyOnly || repairFlag || uninstall ||
+		dryRun || planFile != "" {
+		return nil
+	}
 	// try to update netupvim
 	if shouldSelfUpdate() {
 		netup.LogInfo("trying to update netupvim")
 		err := netup.Update(
+			ctx,
 			targetDir,
 			workDir,
-			netupPack,
+			selfPack,
 			netup.Arch{Name: "X86"},
 			restore)
 		if err != nil {
@@ -114,6 +403,7 @@ func showHelp() {
 	fmt.Fprintf(os.Stderr, `%[1]s is tool to upgrade/install Vim (+kaoriya) in/to target dir.
 
 Usage: %[1]s [options]
+       %[1]s serve [options]
 
 Options are:
 `, filepath.Base(os.Args[0]))
@@ -125,7 +415,13 @@ func showVersion() {
 }
 
 func main() {
-	if err := run(); err != nil {
+	ctx := withSignals(context.Background())
+	if err := run(ctx); err != nil {
+		if ctx.Err() != nil {
+			netup.LogNotice("cancelled")
+			netup.Exit(exitCancelled)
+		}
 		netup.LogFatal(err)
 	}
+	netup.Exit(exitCode)
 }
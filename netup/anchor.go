@@ -0,0 +1,145 @@
+package netup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// anchorInfo is content of anchor file.  First line is the time when last
+// update was done, and following lines are properties like "key\tvalue".
+type anchorInfo struct {
+	time time.Time
+
+	// archive is name of the archive which was extracted.
+	archive string
+
+	// target is absolute path of the directory which the archive was
+	// extracted to.
+	target string
+
+	// source is id of the source which provided the archive.
+	source string
+
+	// prerelease is true when the archive is a pre-release.
+	prerelease bool
+}
+
+// loadAnchor loads anchor file.  It returns empty anchor without errors when
+// the file doesn't exist or incomplete.
+func loadAnchor(name string) (*anchorInfo, error) {
+	a := &anchorInfo{}
+	f, err := os.Open(name)
+	if err != nil {
+		return a, nil
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	l, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return a, nil
+	}
+	l = strings.TrimSpace(l)
+	if l == "" {
+		return a, nil
+	}
+	t, err := time.Parse(time.RFC3339, l)
+	if err != nil {
+		return nil, err
+	}
+	a.time = t
+	for {
+		l, err := r.ReadString('\n')
+		if s := strings.SplitN(strings.TrimSpace(l), "\t", 2); len(s) == 2 {
+			switch s[0] {
+			case "archive":
+				a.archive = s[1]
+			case "target":
+				a.target = s[1]
+			case "source":
+				a.source = s[1]
+			case "prerelease":
+				a.prerelease = s[1] == "true"
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return a, nil
+}
+
+// loadAnchorFor loads anchor of an installation for current source.  An empty
+// anchor is returned when the installation was provided by other source, to
+// migrate it by full extraction and cleanup of files of old distribution.
+// Unknown source (installed by old version) is treated as same one.
+func loadAnchorFor(c *context) (*anchorInfo, error) {
+	a, err := loadAnchor(c.anchorPath())
+	if err != nil {
+		return nil, err
+	}
+	prev := a.source
+	if prev == "" {
+		if rc, err := loadRecipe(c.recipePath()); err == nil {
+			prev = rc.source
+		}
+	}
+	if curr := c.source.id(); prev != "" && prev != curr {
+		logInfo("source was changed from %s to %s", prev, curr)
+		msgEmit("source_changed", msgEvent{
+			"package": c.source.name(),
+			"from":    prev,
+			"to":      curr,
+		})
+		msgPrintf("source was changed, all files will be updated\n")
+		return &anchorInfo{}, nil
+	}
+	return a, nil
+}
+
+func (a *anchorInfo) save(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.WriteString(f, a.time.Format(time.RFC3339)+"\n"); err != nil {
+		return err
+	}
+	var prerelease string
+	if a.prerelease {
+		prerelease = "true"
+	}
+	for _, p := range [][2]string{
+		{"archive", a.archive},
+		{"target", a.target},
+		{"source", a.source},
+		{"prerelease", prerelease},
+	} {
+		if p[1] == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(f, "%s\t%s\n", p[0], p[1]); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+// String returns a string to show the anchor.
+func (a *anchorInfo) String() string {
+	if a.time.IsZero() {
+		return "(not installed)"
+	}
+	if a.archive == "" {
+		return fmt.Sprintf("updated at %s", a.time.Format(time.RFC3339))
+	}
+	s := a.archive
+	if a.prerelease {
+		s += " (pre-release)"
+	}
+	return fmt.Sprintf("%s (updated at %s)", s, a.time.Format(time.RFC3339))
+}
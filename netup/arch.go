@@ -11,7 +11,8 @@ type Arch struct {
 	// Name for architecture, like "X86", "AMD64"
 	Name string
 
-	// Hint is a file be used to guess architecture.
+	// Hint is a file be used to guess architecture.  Relative path is
+	// resolved from target directory.
 	Hint string
 }
 
@@ -20,5 +21,8 @@ func (a *Arch) detectCPU(dir string) (arch.CPU, error) {
 	if cpu != 0 {
 		return cpu, nil
 	}
+	if filepath.IsAbs(a.Hint) {
+		return arch.Exe(a.Hint)
+	}
 	return arch.Exe(filepath.Join(dir, a.Hint))
 }
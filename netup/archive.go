@@ -0,0 +1,304 @@
+package netup
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koron/go-zipext"
+)
+
+// archiveEntry is a regular file in an archive.
+type archiveEntry struct {
+	// name is slash separated path in the archive.
+	name    string
+	size    uint64
+	crc32   uint32
+	modTime time.Time
+	open    func() (io.ReadCloser, error)
+}
+
+// archive is an opened archive.  Entries are regular files only, and their
+// open can be called concurrently.
+type archive interface {
+	entries() []*archiveEntry
+	Close() error
+}
+
+// archiveFormat is a supported format of archive.  Format of a file is
+// detected by magic bytes, or by extension when magic bytes don't match.
+type archiveFormat struct {
+	name  string
+	magic []byte
+	exts  []string
+	open  func(name, tmpDir string, l *archiveLimits) (archive, error)
+}
+
+var archiveFormats = []*archiveFormat{
+	{
+		name:  "zip",
+		magic: []byte("PK\x03\x04"),
+		exts:  []string{".zip"},
+		open:  openZipArchive,
+	},
+	{
+		name:  "tar.gz",
+		magic: []byte("\x1f\x8b"),
+		exts:  []string{".tar.gz", ".tgz"},
+		open:  openTarGzArchive,
+	},
+	{
+		name:  "tar.xz",
+		magic: []byte("\xfd7zXZ\x00"),
+		exts:  []string{".tar.xz", ".txz"},
+		open:  openTarXzArchive,
+	},
+	{
+		name:  "7z",
+		magic: []byte("7z\xbc\xaf\x27\x1c"),
+		exts:  []string{".7z"},
+		open:  open7zArchive,
+	},
+}
+
+// detectArchiveFormat detects format of an archive file.
+func detectArchiveFormat(name string) (*archiveFormat, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 8)
+	n, err := io.ReadFull(f, head)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	for _, af := range archiveFormats {
+		if bytes.HasPrefix(head[:n], af.magic) {
+			return af, nil
+		}
+	}
+	if af := archiveFormatByExt(name); af != nil {
+		return af, nil
+	}
+	return nil, fmt.Errorf("unsupported archive format: %s", filepath.Base(name))
+}
+
+func archiveFormatByExt(name string) *archiveFormat {
+	lower := strings.ToLower(name)
+	for _, af := range archiveFormats {
+		for _, ext := range af.exts {
+			if strings.HasSuffix(lower, ext) {
+				return af
+			}
+		}
+	}
+	return nil
+}
+
+// archiveExt returns extension of an archive name, like ".zip" or ".tar.gz".
+func archiveExt(name string) string {
+	lower := strings.ToLower(name)
+	if af := archiveFormatByExt(lower); af != nil {
+		for _, ext := range af.exts {
+			if strings.HasSuffix(lower, ext) {
+				return name[len(name)-len(ext):]
+			}
+		}
+	}
+	return filepath.Ext(name)
+}
+
+// openArchive opens an archive.  Formats which can't be read randomly are
+// expanded to a temporary directory under tmpDir until closed.  It fails when
+// files in the archive exceed limits, before anything is extracted.
+func openArchive(name, tmpDir string) (archive, error) {
+	af, err := detectArchiveFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	l, err := newArchiveLimits(name)
+	if err != nil {
+		return nil, err
+	}
+	logInfo("open %s archive: %s", af.name, name)
+	a, err := af.open(name, tmpDir, l)
+	if err != nil {
+		return nil, err
+	}
+	files := a.entries()
+	for _, ae := range files {
+		// some archivers use backslashes as path separators.
+		ae.name = strings.Replace(ae.name, "\\", "/", -1)
+	}
+	if err := l.check(len(files), totalUncompressedSize(files)); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// archiveLimits limits files in an archive, to protect from decompression
+// bombs.  Zero means no limit.
+type archiveLimits struct {
+	entries    int
+	size       uint64
+	ratio      uint64
+	compressed uint64
+}
+
+// newArchiveLimits returns limits for an archive file by ArchiveMaxEntries,
+// ArchiveMaxSize and ArchiveMaxRatio.
+func newArchiveLimits(name string) (*archiveLimits, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	l := &archiveLimits{compressed: uint64(fi.Size())}
+	if ArchiveMaxEntries > 0 {
+		l.entries = ArchiveMaxEntries
+	}
+	if ArchiveMaxSize > 0 {
+		l.size = uint64(ArchiveMaxSize)
+	}
+	if ArchiveMaxRatio > 0 {
+		l.ratio = uint64(ArchiveMaxRatio)
+	}
+	return l, nil
+}
+
+// maxSize returns max total size of files, or zero for no limit.
+func (l *archiveLimits) maxSize() uint64 {
+	max := l.size
+	if l.ratio > 0 {
+		if n := l.compressed * l.ratio; max == 0 || n < max {
+			max = n
+		}
+	}
+	return max
+}
+
+// check checks number and total size of files in an archive.
+func (l *archiveLimits) check(count int, total uint64) error {
+	if l.entries > 0 && count > l.entries {
+		return fmt.Errorf("too many files in archive: more than %d", l.entries)
+	}
+	if l.size > 0 && total > l.size {
+		return fmt.Errorf("archive is too large: more than %d bytes when extracted", l.size)
+	}
+	if l.ratio > 0 && total > l.compressed*l.ratio {
+		return fmt.Errorf("archive is too large: more than %d times of its size when extracted", l.ratio)
+	}
+	return nil
+}
+
+// zipArchive is an archive of zip, which has CRC32 of files.
+type zipArchive struct {
+	zr    *zip.ReadCloser
+	files []*archiveEntry
+}
+
+func openZipArchive(name, tmpDir string, l *archiveLimits) (archive, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	za := &zipArchive{zr: zr}
+	for _, zf := range zr.File {
+		if m := zf.Mode(); m.IsDir() {
+			continue
+		} else if !m.IsRegular() {
+			logInfo("ignore non-regular file in archive: %s", zf.Name)
+			continue
+		}
+		za.files = append(za.files, &archiveEntry{
+			name:    zf.Name,
+			size:    zf.UncompressedSize64,
+			crc32:   zf.CRC32,
+			modTime: zipext.Parse(zf).ModTime(),
+			open:    zf.Open,
+		})
+	}
+	return za, nil
+}
+
+func (za *zipArchive) entries() []*archiveEntry {
+	return za.files
+}
+
+func (za *zipArchive) Close() error {
+	return za.zr.Close()
+}
+
+// spoolArchive is an archive which was expanded to a temporary directory,
+// for formats which can be read only sequentially.  CRC32 of files are
+// calculated while expanding.  Expanding stops when files exceed limits.
+type spoolArchive struct {
+	dir    string
+	files  []*archiveEntry
+	size   uint64
+	limits *archiveLimits
+}
+
+func newSpoolArchive(tmpDir string, l *archiveLimits) (*spoolArchive, error) {
+	dir, err := ioutil.TempDir(tmpDir, "spool")
+	if err != nil {
+		return nil, err
+	}
+	return &spoolArchive{dir: dir, limits: l}, nil
+}
+
+// add expands a file to the spool.
+func (sa *spoolArchive) add(name string, modTime time.Time, r io.Reader) error {
+	if err := sa.limits.check(len(sa.files)+1, sa.size); err != nil {
+		return err
+	}
+	// read one more byte than limit to detect exceeding.
+	if max := sa.limits.maxSize(); max > 0 && max-sa.size < math.MaxInt64 {
+		r = io.LimitReader(r, int64(max-sa.size)+1)
+	}
+	p := filepath.Join(sa.dir, strconv.Itoa(len(sa.files)))
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	sa.size += uint64(n)
+	if err := sa.limits.check(len(sa.files)+1, sa.size); err != nil {
+		return err
+	}
+	sa.files = append(sa.files, &archiveEntry{
+		name:    name,
+		size:    uint64(n),
+		crc32:   h.Sum32(),
+		modTime: modTime,
+		open: func() (io.ReadCloser, error) {
+			return os.Open(p)
+		},
+	})
+	return nil
+}
+
+func (sa *spoolArchive) entries() []*archiveEntry {
+	return sa.files
+}
+
+func (sa *spoolArchive) Close() error {
+	return os.RemoveAll(sa.dir)
+}
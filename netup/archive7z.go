@@ -0,0 +1,40 @@
+package netup
+
+import (
+	"github.com/bodgit/sevenzip"
+)
+
+// open7zArchive expands a 7z archive to spool, because files in a solid
+// block can't be read randomly in efficient.
+func open7zArchive(name, tmpDir string, l *archiveLimits) (archive, error) {
+	zr, err := sevenzip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	sa, err := newSpoolArchive(tmpDir, l)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if m := f.Mode(); m.IsDir() {
+			continue
+		} else if !m.IsRegular() {
+			logInfo("ignore non-regular file in archive: %s", f.Name)
+			continue
+		}
+		err := func() error {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			return sa.add(f.Name, f.Modified, r)
+		}()
+		if err != nil {
+			sa.Close()
+			return nil, err
+		}
+	}
+	return sa, nil
+}
@@ -0,0 +1,352 @@
+package netup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ulikunitz/xz"
+)
+
+// writeTestTar creates a compressed tarball which has files under "./vim/"
+// dir.
+func writeTestTar(t *testing.T, name string, files map[string]string, compress func(io.Writer) (io.WriteCloser, error)) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cw, err := compress(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(cw)
+	tw.WriteHeader(&tar.Header{Name: "./vim/", Typeflag: tar.TypeDir, Mode: 0755})
+	names := make([]string, 0, len(files))
+	for k := range files {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     "./vim/" + k,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(files[k])),
+			ModTime:  time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(files[k]))
+	}
+	tw.WriteHeader(&tar.Header{Name: "./vim/link", Typeflag: tar.TypeSymlink, Linkname: "vim.exe"})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func xzWriter(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+func TestExtractTarball(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		compress func(io.Writer) (io.WriteCloser, error)
+	}{
+		{"vim.tar.gz", gzipWriter},
+		{"vim.tar.xz", xzWriter},
+		// detected by magic bytes, like archives from mirrors.
+		{"latest.zip", xzWriter},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "netup")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			c := newTestContext(t, dir, &DirectSource{Name: "vim", URL: "https://example.com/" + tc.name, Strip: 1})
+			files := map[string]string{
+				"vim.exe":      "vim",
+				"runtime/a.vm": "runtime",
+			}
+			archiveName := filepath.Join(c.tmpDir, tc.name)
+			writeTestTar(t, archiveName, files, tc.compress)
+			if err := extract(c, archiveName, &anchorInfo{time: time.Now()}); err != nil {
+				t.Fatalf("extract failed: %s", err)
+			}
+			for k, v := range files {
+				checkTestFile(t, filepath.Join(c.targetDir, k), v)
+			}
+			if _, err := os.Lstat(filepath.Join(c.targetDir, "link")); !os.IsNotExist(err) {
+				t.Errorf("symlink should not be extracted: %v", err)
+			}
+			fi, err := os.Stat(filepath.Join(c.targetDir, "vim.exe"))
+			if err != nil || !fi.ModTime().Equal(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)) {
+				t.Errorf("modification time should be kept: %v %v", fi, err)
+			}
+			rc, err := loadRecipe(c.recipePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi := rc.files["vim.exe"]; fi.hash != crc32.ChecksumIEEE([]byte("vim")) || fi.size != 3 || fi.sha256 == "" {
+				t.Errorf("recipe should have CRC32 calculated at extraction: %+v", fi)
+			}
+
+			// unchanged files are skipped, and exe is rotated.
+			files["vim.exe"] = "vim2"
+			writeTestTar(t, archiveName, files, tc.compress)
+			if err := extract(c, archiveName, &anchorInfo{time: time.Now()}); err != nil {
+				t.Fatalf("extract failed: %s", err)
+			}
+			checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "vim2")
+			checkTestFile(t, filepath.Join(c.targetDir, "vim.1.exe"), "vim")
+			spool, _ := filepath.Glob(filepath.Join(c.tmpDir, "spool*"))
+			if len(spool) != 0 {
+				t.Errorf("spool should be removed: %v", spool)
+			}
+		})
+	}
+}
+
+func TestExtract7z(t *testing.T) {
+	// test_data/vim.7z has an LZMA2 solid block of "vim/vim.exe",
+	// "vim\runtime\a.vim" and a symlink "vim/link".
+	b, err := ioutil.ReadFile(filepath.Join("test_data", "vim.7z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"vim.7z", "latest.zip"} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "netup")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			c := newTestContext(t, dir, &DirectSource{Name: "vim", URL: "https://example.com/" + name, Strip: 1})
+			archiveName := filepath.Join(c.tmpDir, name)
+			if err := ioutil.WriteFile(archiveName, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := extract(c, archiveName, &anchorInfo{time: time.Now()}); err != nil {
+				t.Fatalf("extract failed: %s", err)
+			}
+			checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "vim")
+			checkTestFile(t, filepath.Join(c.targetDir, "runtime", "a.vim"), "runtime")
+			if _, err := os.Lstat(filepath.Join(c.targetDir, "link")); !os.IsNotExist(err) {
+				t.Errorf("symlink should not be extracted: %v", err)
+			}
+			fi, err := os.Stat(filepath.Join(c.targetDir, "vim.exe"))
+			if err != nil || !fi.ModTime().Equal(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)) {
+				t.Errorf("modification time should be kept: %v %v", fi, err)
+			}
+			rc, err := loadRecipe(c.recipePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi := rc.files["runtime/a.vim"]; fi.hash != crc32.ChecksumIEEE([]byte("runtime")) || fi.size != 7 {
+				t.Errorf("recipe should have CRC32 calculated at extraction: %+v", fi)
+			}
+			spool, _ := filepath.Glob(filepath.Join(c.tmpDir, "spool*"))
+			if len(spool) != 0 {
+				t.Errorf("spool should be removed: %v", spool)
+			}
+		})
+	}
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, want := range map[string]string{
+		"a.zip":    "zip",
+		"a.tgz":    "tar.gz",
+		"a.tar.xz": "tar.xz",
+		"a.7z":     "7z",
+	} {
+		// empty files are detected by extension.
+		p := filepath.Join(dir, name)
+		writeTestFile(t, p, "")
+		af, err := detectArchiveFormat(p)
+		if err != nil || af.name != want {
+			t.Errorf("format of %s should be %s: %v %v", name, want, af, err)
+		}
+	}
+	p := filepath.Join(dir, "a.txt")
+	writeTestFile(t, p, "hello")
+	if _, err := detectArchiveFormat(p); err == nil {
+		t.Error("text file should not be supported")
+	}
+	if s := archiveExt("vim-9.0-x64.TAR.GZ"); s != ".TAR.GZ" {
+		t.Errorf("unexpected extension: %s", s)
+	}
+}
+
+// writeRawZip creates a zip which has files with raw names.  Files which have
+// "->" in value are stored as symlinks.
+func writeRawZip(t *testing.T, name string, files map[string]string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for k, v := range files {
+		fh := &zip.FileHeader{Name: k, Method: zip.Deflate}
+		if strings.HasPrefix(v, "->") {
+			fh.SetMode(os.ModeSymlink | 0777)
+			v = v[2:]
+		}
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(v))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractUnsafePath(t *testing.T) {
+	for _, name := range []string{
+		"vim/../../evil.txt",
+		"../evil.txt",
+		"/evil.txt",
+		"C:/evil.txt",
+		"vim/vim.exe:evil",
+		`vim\..\..\evil.txt`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "netup")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			c := newTestContext(t, dir, &DirectSource{Name: "vim", Strip: 1})
+			zipName := filepath.Join(c.tmpDir, "vim.zip")
+			writeRawZip(t, zipName, map[string]string{
+				"vim/vim.exe": "vim",
+				name:          "evil",
+			})
+			err = extract(c, zipName, &anchorInfo{time: time.Now()})
+			if err == nil || !strings.Contains(err.Error(), "unsafe path in archive") {
+				t.Fatalf("extract should fail by unsafe path: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(c.targetDir, "vim.exe")); !os.IsNotExist(err) {
+				t.Errorf("nothing should be extracted: %v", err)
+			}
+			if _, err := makePlan(c, zipName, time.Now()); err == nil {
+				t.Error("makePlan should fail by unsafe path")
+			}
+		})
+	}
+}
+
+func TestExtractSymlinkAndStripped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestContext(t, dir, &DirectSource{Name: "vim", Strip: 1})
+	zipName := filepath.Join(c.tmpDir, "vim.zip")
+	writeRawZip(t, zipName, map[string]string{
+		"vim/vim.exe":       "vim",
+		"vim/runtime/link":  "->../../../evil.txt",
+		"README.txt":        "stripped",
+		`vim\runtime\a.vim`: "a",
+	})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "vim")
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "a.vim"), "a")
+	if _, err := os.Lstat(filepath.Join(c.targetDir, "runtime", "link")); !os.IsNotExist(err) {
+		t.Errorf("symlink should not be extracted: %v", err)
+	}
+	rc, err := loadRecipe(c.recipePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.files) != 2 {
+		t.Errorf("recipe should have 2 files: %v", rc.files)
+	}
+}
+
+func TestArchiveLimits(t *testing.T) {
+	defer func(n int, size int64, ratio int) {
+		ArchiveMaxEntries, ArchiveMaxSize, ArchiveMaxRatio = n, size, ratio
+	}(ArchiveMaxEntries, ArchiveMaxSize, ArchiveMaxRatio)
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestContext(t, dir, &DirectSource{Name: "vim", Strip: 1})
+	files := map[string]string{
+		"vim.exe": "vim",
+		"zero":    string(bytes.Repeat([]byte{0}, 1<<20)),
+	}
+	zipName := filepath.Join(c.tmpDir, "vim.zip")
+	writeTestZip(t, zipName, files)
+	tarName := filepath.Join(c.tmpDir, "vim.tar.gz")
+	writeTestTar(t, tarName, files, gzipWriter)
+
+	for _, tc := range []struct {
+		entries int
+		size    int64
+		ratio   int
+		err     string
+	}{
+		{1, 0, 0, "too many files"},
+		{0, 1 << 19, 0, "more than 524288 bytes"},
+		{0, 0, 10, "more than 10 times"},
+		{2, 2 << 20, 0, ""},
+		{0, 0, 0, ""},
+	} {
+		ArchiveMaxEntries, ArchiveMaxSize, ArchiveMaxRatio = tc.entries, tc.size, tc.ratio
+		for _, name := range []string{zipName, tarName} {
+			a, err := openArchive(name, c.tmpDir)
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("open %s with %+v failed: %s", name, tc, err)
+					continue
+				}
+				a.Close()
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("open %s with %+v should fail by %q: %v", name, tc, tc.err, err)
+			}
+			if a != nil {
+				a.Close()
+			}
+		}
+	}
+	spool, _ := filepath.Glob(filepath.Join(c.tmpDir, "spool*"))
+	if len(spool) != 0 {
+		t.Errorf("spool should be removed: %v", spool)
+	}
+}
@@ -0,0 +1,68 @@
+package netup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+func openTarGzArchive(name, tmpDir string, l *archiveLimits) (archive, error) {
+	return openTarArchive(name, tmpDir, l, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	})
+}
+
+func openTarXzArchive(name, tmpDir string, l *archiveLimits) (archive, error) {
+	return openTarArchive(name, tmpDir, l, func(r io.Reader) (io.Reader, error) {
+		return xz.NewReader(r)
+	})
+}
+
+// openTarArchive expands a compressed tarball to spool.  Regular files are
+// extracted, and others like directories and links are ignored.
+func openTarArchive(name, tmpDir string, l *archiveLimits, decompress func(io.Reader) (io.Reader, error)) (archive, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	sa, err := newSpoolArchive(tmpDir, l)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(r)
+	err = func() error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			switch hdr.Typeflag {
+			case tar.TypeReg:
+				name := strings.TrimPrefix(hdr.Name, "./")
+				if err := sa.add(name, hdr.ModTime, tr); err != nil {
+					return err
+				}
+			case tar.TypeDir:
+			default:
+				logInfo("ignore non-regular file in archive: %s", hdr.Name)
+			}
+		}
+	}()
+	if err != nil {
+		sa.Close()
+		return nil, err
+	}
+	return sa, nil
+}
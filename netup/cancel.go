@@ -0,0 +1,19 @@
+package netup
+
+import (
+	stdctx "context"
+	"io"
+)
+
+// ctxReader is a reader which fails after the context is cancelled.
+type ctxReader struct {
+	ctx stdctx.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,169 @@
+package netup
+
+import (
+	"bufio"
+	"bytes"
+	stdctx "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errChecksumNotFound = errors.New("checksum not found for archive")
+	errChecksumInvalid  = errors.New("invalid SHA-256 checksum")
+)
+
+// maxChecksumSize is upper limit of checksum file's size.
+const maxChecksumSize = 1024 * 1024
+
+func calcSHA256(name string) (string, error) {
+	r, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func normalizeSHA256(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) != sha256.Size*2 {
+		return "", errChecksumInvalid
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", errChecksumInvalid
+	}
+	return s, nil
+}
+
+// ValidateSHA256 checks format of SHA-256 checksum.
+func ValidateSHA256(s string) error {
+	_, err := normalizeSHA256(s)
+	return err
+}
+
+// verifySHA256 checks SHA-256 checksum of a file.  The file is removed when
+// it doesn't match.  Empty expected means no checks.
+func verifySHA256(name, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	want, err := normalizeSHA256(expected)
+	if err != nil {
+		return err
+	}
+	got, err := calcSHA256(name)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		os.Remove(name)
+		return fmt.Errorf("checksum mismatch for %s: expected %s but got %s",
+			filepath.Base(name), want, got)
+	}
+	logInfo("verified SHA-256 checksum of %s: %s", name, got)
+	return nil
+}
+
+// fetchChecksum downloads checksum file from URL with extra request headers h
+// (optional), then extracts checksum for the file name.
+func fetchChecksum(ctx stdctx.Context, inURL string, h http.Header, name string) (string, error) {
+	logInfo("fetch checksum from %s", inURL)
+	var b []byte
+	err := withRetry(ctx, "fetch checksum", func() error {
+		var err error
+		b, err = tryFetchChecksum(ctx, inURL, h)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return parseChecksum(b, name)
+}
+
+func tryFetchChecksum(ctx stdctx.Context, inURL string, h http.Header) ([]byte, error) {
+	req, err := http.NewRequest("GET", inURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	client := http.Client{Timeout: downloadTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPError(resp, fmt.Sprintf("unexpected response for checksum: %s", resp.Status))
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxChecksumSize))
+}
+
+// parseChecksum extracts checksum for the file name from content of
+// "*.sha256" or "SHA256SUMS" file.  Supported formats are "{hash}" alone,
+// "{hash}  {name}", "{hash} *{name}" and "SHA256 ({name}) = {hash}".  Names
+// may have spaces.
+func parseChecksum(b []byte, name string) (string, error) {
+	var (
+		only  string
+		count int
+	)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		count++
+		if strings.HasPrefix(l, "SHA256 (") {
+			n := strings.LastIndex(l, ") = ")
+			if n < 0 {
+				continue
+			}
+			if checksumBase(l[8:n]) == name {
+				return normalizeSHA256(l[n+4:])
+			}
+			continue
+		}
+		// name is rest of the first whitespaces, which may have spaces.
+		n := strings.IndexAny(l, " \t")
+		if n < 0 {
+			only = l
+			continue
+		}
+		sum, rest := l[:n], strings.TrimLeft(l[n:], " \t")
+		if checksumBase(strings.TrimPrefix(rest, "*")) == name {
+			return normalizeSHA256(sum)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	if count == 1 && only != "" {
+		return normalizeSHA256(only)
+	}
+	return "", errChecksumNotFound
+}
+
+// checksumBase returns the last element of a name in checksum file, which may
+// be separated by slashes or backslashes.
+func checksumBase(name string) string {
+	if n := strings.LastIndexAny(name, "/\\"); n >= 0 {
+		return name[n+1:]
+	}
+	return name
+}
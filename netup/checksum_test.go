@@ -0,0 +1,187 @@
+package netup
+
+import (
+	stdctx "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testSum1 = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testSum2 = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+func TestParseChecksum(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		want    string
+		err     error
+	}{
+		// "*.sha256" which has only a hash.
+		{testSum1 + "\n", testSum1, nil},
+		{strings.ToUpper(testSum1), testSum1, nil},
+		// "*.sha256" which has a hash and a name.
+		{testSum1 + "  vim.zip\n", testSum1, nil},
+		// SHA256SUMS in text and binary mode.
+		{testSum2 + "  other.zip\n" + testSum1 + "  vim.zip\n", testSum1, nil},
+		{"# comment\n" + testSum2 + " *other.zip\r\n" + testSum1 + " *vim.zip\r\n", testSum1, nil},
+		{testSum2 + "  dist/other.zip\n" + testSum1 + "  dist\\vim.zip\n", testSum1, nil},
+		// BSD style.
+		{"SHA256 (other.zip) = " + testSum2 + "\nSHA256 (vim.zip) = " + testSum1 + "\n", testSum1, nil},
+		// missing entry.
+		{testSum2 + "  other.zip\n" + testSum1 + "  vim.exe\n", "", errChecksumNotFound},
+		{"SHA256 (other.zip) = " + testSum2 + "\n", "", errChecksumNotFound},
+		{"", "", errChecksumNotFound},
+		// single hash is ambiguous with other lines.
+		{testSum1 + "\n" + testSum2 + "  other.zip\n", "", errChecksumNotFound},
+		// invalid hash.
+		{"0123  vim.zip\n", "", errChecksumInvalid},
+		{strings.Repeat("z", 64) + "\n", "", errChecksumInvalid},
+	} {
+		got, err := parseChecksum([]byte(tc.content), "vim.zip")
+		if got != tc.want || err != tc.err {
+			t.Errorf("parseChecksum(%q) should return %q, %v: %q, %v", tc.content, tc.want, tc.err, got, err)
+		}
+	}
+
+	// names with spaces.
+	for _, content := range []string{
+		testSum2 + "  my vim.zip.bak\n" + testSum1 + "  my vim.zip\n",
+		testSum2 + " *my\n" + testSum1 + " *dist/my vim.zip\n",
+	} {
+		if got, err := parseChecksum([]byte(content), "my vim.zip"); got != testSum1 || err != nil {
+			t.Errorf("parseChecksum(%q) should match name with spaces: %q, %v", content, got, err)
+		}
+	}
+	if _, err := parseChecksum([]byte(testSum1+"  my other.zip\n"), "my vim.zip"); err != errChecksumNotFound {
+		t.Errorf("parseChecksum should fail for other name with spaces: %v", err)
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "vim.zip")
+	writeTestFile(t, name, "archive")
+	h := sha256.Sum256([]byte("archive"))
+	sum := hex.EncodeToString(h[:])
+
+	if err := verifySHA256(name, ""); err != nil {
+		t.Errorf("empty checksum should not be verified: %s", err)
+	}
+	if err := verifySHA256(name, " "+strings.ToUpper(sum)+"\n"); err != nil {
+		t.Errorf("checksum should match: %s", err)
+	}
+	if err := verifySHA256(name, "foo"); err != errChecksumInvalid {
+		t.Errorf("invalid checksum should be rejected: %v", err)
+	}
+	err = verifySHA256(name, testSum1)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for vim.zip") {
+		t.Errorf("mismatch should fail: %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("mismatched file should be removed: %v", err)
+	}
+}
+
+func TestFetchChecksum(t *testing.T) {
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.URL.Path != "/SHA256SUMS" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testSum2 + "  other.zip\n" + testSum1 + " *vim.zip\n"))
+	}))
+	defer ts.Close()
+	ctx := stdctx.Background()
+	h := http.Header{"Authorization": {"token secret"}}
+	sum, err := fetchChecksum(ctx, ts.URL+"/SHA256SUMS", h, "vim.zip")
+	if err != nil || sum != testSum1 {
+		t.Errorf("fetchChecksum should return checksum for vim.zip: %q %v", sum, err)
+	}
+	if auth != "token secret" {
+		t.Errorf("headers should be sent: %q", auth)
+	}
+	if _, err := fetchChecksum(ctx, ts.URL+"/SHA256SUMS", nil, "vim.exe"); err != errChecksumNotFound {
+		t.Errorf("missing entry should fail: %v", err)
+	}
+	if _, err := fetchChecksum(ctx, ts.URL+"/none.sha256", nil, "vim.zip"); err == nil {
+		t.Error("missing checksum file should fail")
+	}
+}
+
+func TestChecksumMismatchAbortsUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	zipName := filepath.Join(dir, "vim.zip")
+	writeTestZip(t, zipName, map[string]string{"vim.exe": "vim"})
+	var archiveRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vim.zip":
+			archiveRequests++
+			http.ServeFile(w, r, zipName)
+		case "/vim.zip.sha256":
+			w.Write([]byte(testSum1 + "  other.zip\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	src := &DirectSource{Name: "vim", URL: ts.URL + "/vim.zip", Strip: 1}
+	c := newTestContext(t, dir, src)
+
+	checkUntouched := func() {
+		t.Helper()
+		fis, err := ioutil.ReadDir(c.targetDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range fis {
+			if fi.Name() != "netupvim" {
+				t.Errorf("target dir should be untouched: %s", fi.Name())
+			}
+		}
+		if _, err := os.Stat(c.recipePath()); !os.IsNotExist(err) {
+			t.Errorf("recipe should not be written: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(c.tmpDir, "vim.zip")); !os.IsNotExist(err) {
+			t.Errorf("mismatched archive should be removed: %v", err)
+		}
+	}
+
+	// mismatch with sha256.
+	src.SHA256 = testSum1
+	err = update(c)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("update should fail by checksum mismatch: %v", err)
+	}
+	checkUntouched()
+
+	// checksum file without entry for the archive, which isn't downloaded.
+	src.SHA256 = ""
+	src.SHA256URL = ts.URL + "/vim.zip.sha256"
+	archiveRequests = 0
+	if err := update(c); err != errChecksumNotFound {
+		t.Fatalf("update should fail by missing checksum: %v", err)
+	}
+	if archiveRequests != 0 {
+		t.Errorf("archive should not be downloaded: %d", archiveRequests)
+	}
+	checkUntouched()
+}
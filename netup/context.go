@@ -1,20 +1,25 @@
 package netup
 
 import (
-	"io"
+	stdctx "context"
 	"net/url"
 	"os"
 	"path/filepath"
-	"time"
 )
 
 type context struct {
+	// ctx is cancelled by signals to abort operations.
+	ctx stdctx.Context
+
 	targetDir string
 	dataDir   string
 	logDir    string
 	tmpDir    string
 	varDir    string
 	source    Source
+
+	// lock is an exclusive lock of dataDir, released by close.
+	lock *lockFile
 }
 
 func (c *context) downloadPath(targetURL string) (string, error) {
@@ -33,29 +38,48 @@ func (c *context) anchorPath() string {
 	return filepath.Join(c.varDir, "anchor.txt")
 }
 
-func (c *context) anchor() (time.Time, error) {
-	f, err := os.Open(c.anchorPath())
-	if err != nil {
-		return time.Time{}, nil
-	}
-	defer f.Close()
-	buf := make([]byte, 25)
-	if _, err := io.ReadFull(f, buf); err != nil {
-		return time.Time{}, nil
-	}
-	return time.Parse(time.RFC3339, string(buf))
+// archiveDir returns a path to cache the last installed archive.
+func (c *context) archiveDir() string {
+	return filepath.Join(c.varDir, "archive")
 }
 
-func (c *context) updateAnchor(t time.Time) error {
-	f, err := os.Create(c.anchorPath())
-	if err != nil {
-		return err
+// cacheArchive keeps an installed archive for repair, and removes archives
+// which were cached before.
+func (c *context) cacheArchive(name string) {
+	dir := c.archiveDir()
+	if err := os.RemoveAll(dir); err != nil {
+		logCleanArchiveFailed(err)
 	}
-	defer f.Close()
-	if _, err := io.WriteString(f, t.Format(time.RFC3339)); err != nil {
-		return err
+	err := os.MkdirAll(dir, 0777)
+	if err == nil {
+		err = os.Rename(name, filepath.Join(dir, filepath.Base(name)))
 	}
-	return f.Sync()
+	if err != nil {
+		logWarn("failed to cache archive: %s", err)
+		if err := os.Remove(name); err != nil {
+			logCleanArchiveFailed(err)
+		}
+	}
+}
+
+// lockPath returns a path of lock file, which is shared by all packages.
+func (c *context) lockPath() string {
+	return workDirLockPath(c.dataDir)
+}
+
+func workDirLockPath(workDir string) string {
+	return filepath.Join(workDir, "lock.txt")
+}
+
+// close releases the lock of work dir.
+func (c *context) close() {
+	c.lock.release()
+	c.lock = nil
+}
+
+// txnDir returns a path of work space for transaction.
+func (c *context) txnDir() string {
+	return filepath.Join(c.varDir, "txn")
 }
 
 func (c *context) resetAnchor() error {
@@ -0,0 +1,339 @@
+package netup
+
+import (
+	stdctx "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type extractProgressor func(curr, max uint64)
+
+func totalUncompressedSize(files []*archiveEntry) uint64 {
+	var sum uint64
+	for _, ae := range files {
+		sum += ae.size
+	}
+	return sum
+}
+
+// archiveFiles returns files to extract in archive under root.  Only the last
+// one is used for duplicated names, and files which are stripped entirely are
+// ignored.  It fails when a file has an unsafe path, before anything is
+// extracted.
+func archiveFiles(a archive, stripCount int, root string) ([]*archiveEntry, error) {
+	entries := a.entries()
+	last := make(map[string]int)
+	for i, ae := range entries {
+		name := stripPath(ae.name, stripCount)
+		if err := checkEntryPath(root, ae.name, name); err != nil {
+			return nil, err
+		}
+		if name == "" {
+			logInfo("ignore file stripped entirely: %s", ae.name)
+			continue
+		}
+		last[name] = i
+	}
+	files := make([]*archiveEntry, 0, len(last))
+	for i, ae := range entries {
+		if n, ok := last[stripPath(ae.name, stripCount)]; ok && n == i {
+			files = append(files, ae)
+		}
+	}
+	return files, nil
+}
+
+// checkEntryPath checks a path of file in archive, and the stripped one is
+// placed under root.  Absolute paths, drive letters and ".." are rejected,
+// because they may write files outside of root (zip slip).
+func checkEntryPath(root, name, stripped string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, ":") {
+		return fmt.Errorf("unsafe path in archive: %q", name)
+	}
+	for _, s := range strings.Split(name, "/") {
+		if s == ".." {
+			return fmt.Errorf("unsafe path in archive: %q", name)
+		}
+	}
+	rel, err := filepath.Rel(root, filepath.Join(root, filepath.FromSlash(stripped)))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("unsafe path in archive: %q", name)
+	}
+	return nil
+}
+
+// stagedFile is a file extracted to stage, which waits to be placed to
+// target.
+type stagedFile struct {
+	staged string
+	target string
+	rotate bool
+	action planAction
+}
+
+// applyStagedFiles places staged files to target with rotation.  It counts
+// actions for files.  It stops between files when ctx is cancelled, then the
+// transaction should be rolled back.
+func applyStagedFiles(ctx stdctx.Context, t *txn, files []stagedFile, counts map[planAction]int) error {
+	for _, sf := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if sf.rotate {
+			if err := t.rotate(sf.target, ExeRotateCount); err != nil {
+				return err
+			}
+			t.msgFile(actionRotate, sf.target)
+			counts[actionRotate]++
+		}
+		if err := t.replace(sf.staged, sf.target); err != nil {
+			return err
+		}
+		t.msgFile(sf.action, sf.target)
+		counts[sf.action]++
+	}
+	return nil
+}
+
+// planner determines actions for files in archive.
+type planner struct {
+	dir        string
+	tmpDir     string
+	stripCount int
+	prev       fileInfoTable
+
+	// owners is used to detect files installed by other packages.
+	owners owners
+}
+
+func newPlanner(c *context, prev fileInfoTable) *planner {
+	return &planner{
+		dir:        c.targetDir,
+		tmpDir:     c.tmpDir,
+		stripCount: c.source.stripCount(),
+		prev:       prev,
+		owners:     c.loadOwners(),
+	}
+}
+
+// planFile determines an action for a file in archive.
+func (pl *planner) planFile(ae *archiveEntry) (fileInfo, planEntry) {
+	zfName := stripPath(ae.name, pl.stripCount)
+	fi := fileInfo{
+		name: zfName,
+		size: ae.size,
+		hash: ae.crc32,
+	}
+	outName := filepath.Join(pl.dir, zfName)
+	e := planEntry{action: actionAdd, name: zfName, target: outName}
+	// conflict with other packages.
+	if owner, ok := pl.owners.owner(outName); ok {
+		e.action = actionConflict
+		e.owner = owner
+		return fi, e
+	}
+	// evacuation and optimization.
+	if p, ok := pl.prev[zfName]; ok {
+		r, err := p.compareWithFile(outName)
+		if err != nil {
+			logCompareFileFailed(err, outName)
+			e.action = actionSkip
+			return fi, e
+		}
+		switch r {
+		case fileNotMatch:
+			e.action = actionEvacuate
+			e.target = evacuateName(outName)
+		case fileIsMatch:
+			// skip un-changed files.
+			if p.hash == ae.crc32 {
+				e.action = actionSkip
+				fi.sha256 = fillSHA256(p, outName)
+				return fi, e
+			}
+			e.action = actionOverwrite
+		}
+	} else if _, err := os.Lstat(outName); err == nil {
+		e.action = actionOverwrite
+	}
+	// rotation.
+	e.rotate = isRotateTarget(zfName)
+	return fi, e
+}
+
+// extractResult is a result to plan and extract a file in archive.
+type extractResult struct {
+	fi     fileInfo
+	e      planEntry
+	staged string
+}
+
+// planFiles determines actions for files in archive concurrently.  Results
+// are in same order with files.
+func (pl *planner) planFiles(files []*archiveEntry) []extractResult {
+	results := make([]extractResult, len(files))
+	parallelEach(len(files), ExtractWorkers, func(i int) error {
+		results[i].fi, results[i].e = pl.planFile(files[i])
+		return nil
+	})
+	return results
+}
+
+// extractEntry determines an action for a file in archive, then extracts it
+// to stage when it is required.
+func extractEntry(pl *planner, t *txn, ae *archiveEntry) (extractResult, error) {
+	var r extractResult
+	r.fi, r.e = pl.planFile(ae)
+	if r.e.action == actionConflict || r.e.action == actionSkip {
+		return r, nil
+	}
+	stageName := t.stagePath(r.fi.name)
+	sum, err := stageFile(ae, stageName)
+	if err != nil {
+		return r, err
+	}
+	r.fi.sha256 = sum
+	r.staged = stageName
+	return r, nil
+}
+
+// stageFile extracts a file in archive to stage with its modification time,
+// and returns its SHA-256 checksum.
+func stageFile(ae *archiveEntry, stageName string) (string, error) {
+	sum, err := extractFile(ae, stageName)
+	if err != nil {
+		return "", err
+	}
+	if !ae.modTime.IsZero() {
+		os.Chtimes(stageName, ae.modTime, ae.modTime)
+	}
+	return sum, nil
+}
+
+// isRotateTarget checks a file should be rotated instead of overwritten,
+// because it may be locked while running.
+func isRotateTarget(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".exe" || ext == ".dll"
+}
+
+// extractArchive extracts changed files in archive to stage of transaction.
+// Files are compared and extracted by ExtractWorkers workers, but results are
+// collected in order of archive, so rotation and evacuation are
+// deterministic.  Workers stop when ctx is cancelled.
+func extractArchive(ctx stdctx.Context, name string, pl *planner, t *txn, ep extractProgressor) (fileInfoTable, []stagedFile, error) {
+	a, err := openArchive(name, pl.tmpDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer a.Close()
+	files, err := archiveFiles(a, pl.stripCount, pl.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		results = make([]extractResult, len(files))
+		max     = totalUncompressedSize(files)
+		mu      sync.Mutex
+		sum     uint64
+	)
+	defer func() {
+		logInfo("extracted %d bytes", sum)
+	}()
+	err = parallelEach(len(files), ExtractWorkers, func(i int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r, err := extractEntry(pl, t, files[i])
+		if err != nil {
+			return err
+		}
+		results[i] = r
+		mu.Lock()
+		sum += files[i].size
+		if ep != nil {
+			ep(sum, max)
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	curr := make(fileInfoTable)
+	var staged []stagedFile
+	for _, r := range results {
+		if r.e.action == actionConflict {
+			logConflict(r.e.target, r.e.owner)
+			continue
+		}
+		curr[r.fi.name] = r.fi
+		if r.staged == "" {
+			continue
+		}
+		staged = append(staged, stagedFile{
+			staged: r.staged,
+			target: r.e.target,
+			rotate: r.e.rotate,
+			action: r.e.action,
+		})
+	}
+	return curr, staged, nil
+}
+
+// extractFile extracts a file in archive, and returns its SHA-256 checksum.
+func extractFile(ae *archiveEntry, name string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return "", err
+	}
+	r, err := ae.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	w, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	defer w.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func stripPath(name string, count int) string {
+	s := strings.Split(name, "/")
+	if count >= len(s) {
+		return ""
+	}
+	return path.Join(s[count:]...)
+}
+
+func evacuateName(name string) string {
+	base, ext := splitExt(name)
+	return base + ".orig" + ext
+}
+
+func rotateName(name string, index int) string {
+	if index == 0 {
+		return name
+	}
+	base, ext := splitExt(name)
+	return base + "." + strconv.Itoa(index) + ext
+}
+
+func splitExt(name string) (base, ext string) {
+	ext = path.Ext(name)
+	base = name[:len(name)-len(ext)]
+	return base, ext
+}
@@ -1,12 +1,11 @@
 package netup
 
 import (
-	"bufio"
-	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 type compareResult int
@@ -17,12 +16,27 @@ const (
 	fileIsMatch
 )
 
-const fileInfoFormat = "%s\t%d\t%08x\n"
-
 type fileInfo struct {
 	name string
 	size uint64
 	hash uint32
+
+	// sha256 is SHA-256 checksum of the file.  It may be empty for files
+	// which were recorded by old version.
+	sha256 string
+}
+
+func (info *fileInfo) parseSizeHash(size, hash string) error {
+	n, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return err
+	}
+	h, err := strconv.ParseUint(hash, 16, 32)
+	if err != nil {
+		return err
+	}
+	info.size, info.hash = n, uint32(h)
+	return nil
 }
 
 func (info fileInfo) compareWithFile(name string) (compareResult, error) {
@@ -36,14 +50,24 @@ func (info fileInfo) compareWithFile(name string) (compareResult, error) {
 	if (uint64)(fi.Size()) != info.size {
 		return fileNotMatch, nil
 	}
-	v, err := calcCRC32(name)
+	// prefer SHA-256 when it is recorded.
+	var match bool
+	if info.sha256 != "" {
+		var v string
+		v, err = calcSHA256(name)
+		match = v == info.sha256
+	} else {
+		var v uint32
+		v, err = calcCRC32(name)
+		match = v == info.hash
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			return fileNotExist, nil
 		}
 		return 0, err
 	}
-	if v != info.hash {
+	if !match {
 		return fileNotMatch, nil
 	}
 	return fileIsMatch, nil
@@ -51,43 +75,6 @@ func (info fileInfo) compareWithFile(name string) (compareResult, error) {
 
 type fileInfoTable map[string]fileInfo
 
-func loadFileInfo(fname string) (fileInfoTable, error) {
-	f, err := os.Open(fname)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	b := bufio.NewReader(f)
-	t := make(fileInfoTable)
-	for {
-		l, err := b.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		fi := fileInfo{}
-		if _, err := fmt.Sscanf(l, fileInfoFormat, &fi.name, &fi.size, &fi.hash); err != nil {
-			return nil, err
-		}
-		t[fi.name] = fi
-	}
-	return t, nil
-}
-
-func (t fileInfoTable) save(fname string) error {
-	f, err := os.Create(fname)
-	if err != nil {
-		return nil
-	}
-	defer f.Close()
-	for _, v := range t {
-		fmt.Fprintf(f, fileInfoFormat, v.name, v.size, v.hash)
-	}
-	return f.Sync()
-}
-
 func calcCRC32(name string) (uint32, error) {
 	r, err := os.Open(name)
 	if err != nil {
@@ -0,0 +1,198 @@
+package netup
+
+import (
+	stdctx "context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var errFileNoArchives = errors.New("no matched archives in directory")
+
+// FileSource represents archives in local directory or file share (UNC
+// path), for hosts without internet.
+type FileSource struct {
+	Name string
+
+	// Path is path of an archive, or a directory which contains archives.
+	Path string
+
+	// NamePat is pattern of archive's name, required when Path is a
+	// directory.  When multiple archives match, one which has the highest
+	// version in its name, or the newest one is used.
+	NamePat *regexp.Regexp
+
+	Strip int
+
+	// SHA256 is expected SHA-256 checksum of the archive (optional).
+	SHA256 string
+
+	// SHA256Pat is pattern of checksum file's name in same directory, like
+	// "*.sha256" or "SHA256SUMS" (optional).
+	SHA256Pat *regexp.Regexp
+}
+
+var _ Source = (*FileSource)(nil)
+
+// findArchive determines an archive file to use.
+func (fs *FileSource) findArchive() (string, os.FileInfo, error) {
+	fi, err := os.Stat(fs.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !fi.IsDir() {
+		return fs.Path, fi, nil
+	}
+	if fs.NamePat == nil {
+		return "", nil, fmt.Errorf("name pattern is required for directory: %s", fs.Path)
+	}
+	files, err := ioutil.ReadDir(fs.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	var best os.FileInfo
+	for _, f := range files {
+		if f.IsDir() || !fs.NamePat.MatchString(f.Name()) {
+			continue
+		}
+		if best == nil {
+			best = f
+			continue
+		}
+		c := compareVersion(parseVersion(f.Name()), parseVersion(best.Name()))
+		if c > 0 || (c == 0 && f.ModTime().After(best.ModTime())) {
+			best = f
+		}
+	}
+	if best == nil {
+		return "", nil, errFileNoArchives
+	}
+	return filepath.Join(fs.Path, best.Name()), best, nil
+}
+
+// isModified checks the archive is newer than anchor.  An archive which has
+// different name from installed one is treated as modified.
+func isModified(fi os.FileInfo, an *anchorInfo) bool {
+	if an.time.IsZero() {
+		return true
+	}
+	if an.archive != "" && an.archive != fi.Name() {
+		return true
+	}
+	return fi.ModTime().After(an.time)
+}
+
+func (fs *FileSource) download(ctx stdctx.Context, d string, an *anchorInfo, f progressFunc) (string, *release, error) {
+	src, fi, err := fs.findArchive()
+	if err != nil {
+		return "", nil, err
+	}
+	if !isModified(fi, an) {
+		return "", nil, errSourceNotModified
+	}
+	sum, err := fs.checksum(fi.Name())
+	if err != nil {
+		return "", nil, err
+	}
+	// copy the archive, because it is removed after extraction.
+	path := filepath.Join(d, fi.Name())
+	if err := copyFile(ctx, src, path, fi.Size(), f); err != nil {
+		return "", nil, err
+	}
+	if err := verifySHA256(path, sum); err != nil {
+		return "", nil, err
+	}
+	return path, &release{name: fi.Name(), updated: fi.ModTime()}, nil
+}
+
+func (fs *FileSource) check(ctx stdctx.Context, an *anchorInfo) (*release, error) {
+	_, fi, err := fs.findArchive()
+	if err != nil {
+		return nil, err
+	}
+	if !isModified(fi, an) {
+		return nil, errSourceNotModified
+	}
+	return &release{name: fi.Name(), updated: fi.ModTime()}, nil
+}
+
+func (fs *FileSource) checksum(name string) (string, error) {
+	if fs.SHA256 != "" || fs.SHA256Pat == nil {
+		return fs.SHA256, nil
+	}
+	dir := fs.Path
+	if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if f.IsDir() || !fs.SHA256Pat.MatchString(f.Name()) {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return "", err
+		}
+		if s, err := parseChecksum(b, name); err == nil {
+			return s, nil
+		}
+	}
+	return "", errChecksumNotFound
+}
+
+func (fs *FileSource) stripCount() int {
+	return fs.Strip
+}
+
+func (fs *FileSource) name() string {
+	return fs.Name
+}
+
+func (fs *FileSource) id() string {
+	if fs.NamePat != nil {
+		return "file:" + fs.Path + ":" + fs.NamePat.String()
+	}
+	return "file:" + fs.Path
+}
+
+func (fs *FileSource) String() string {
+	if fs.NamePat != nil {
+		return fmt.Sprintf("file: path=%s pattern=%s", fs.Path, fs.NamePat.String())
+	}
+	return fmt.Sprintf("file: path=%s", fs.Path)
+}
+
+// copyFile copies a file with progress.  Modification time is kept.
+func copyFile(ctx stdctx.Context, src, dst string, size int64, pf progressFunc) error {
+	logInfo("copy file %s to %s", src, dst)
+	msgPrintf("copy %s\n", src)
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(&progressWriter{w: w, f: pf, m: size}, &ctxReader{ctx: ctx, r: r})
+	if err2 := w.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if fi, err := r.Stat(); err == nil {
+		t := fi.ModTime()
+		os.Chtimes(dst, t, t)
+	}
+	return nil
+}
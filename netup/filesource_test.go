@@ -0,0 +1,97 @@
+package netup
+
+import (
+	stdctx "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "share")
+	out := filepath.Join(dir, "out")
+	os.MkdirAll(src, 0777)
+	os.MkdirAll(out, 0777)
+
+	now := time.Now().Truncate(time.Second)
+	for _, f := range []struct {
+		name string
+		mod  time.Time
+	}{
+		{"vim-8.1.0100-win64.zip", now},
+		{"vim-8.1.1234-win64.zip", now.Add(-time.Hour)},
+		{"vim-8.1.0999-win64.zip", now.Add(time.Hour)},
+		{"readme.txt", now.Add(2 * time.Hour)},
+	} {
+		p := filepath.Join(src, f.name)
+		writeTestFile(t, p, f.name)
+		os.Chtimes(p, f.mod, f.mod)
+	}
+
+	fs := &FileSource{Path: src, NamePat: regexp.MustCompile(`^vim-.*\.zip$`)}
+	r, err := fs.check(stdctx.Background(), &anchorInfo{})
+	if err != nil {
+		t.Fatalf("check failed: %s", err)
+	}
+	if r.name != "vim-8.1.1234-win64.zip" {
+		t.Errorf("highest version should be chosen: %s", r.name)
+	}
+
+	p, _, err := fs.download(stdctx.Background(), out, &anchorInfo{}, nil)
+	if err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+	checkTestFile(t, p, "vim-8.1.1234-win64.zip")
+
+	// same archive is installed already.
+	an := &anchorInfo{time: now, archive: "vim-8.1.1234-win64.zip"}
+	if _, err := fs.check(stdctx.Background(), an); err != errSourceNotModified {
+		t.Errorf("check should return errSourceNotModified: %v", err)
+	}
+	// archive which has different name is treated as newer.
+	an.archive = "vim-8.1.0100-win64.zip"
+	if _, err := fs.check(stdctx.Background(), an); err != nil {
+		t.Errorf("check should find update: %v", err)
+	}
+}
+
+func TestFileSourceChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "share")
+	out := filepath.Join(dir, "out")
+	os.MkdirAll(src, 0777)
+	os.MkdirAll(out, 0777)
+	writeTestFile(t, filepath.Join(src, "my vim.zip"), "archive")
+	sum, err := calcSHA256(filepath.Join(src, "my vim.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &FileSource{
+		Path:      src,
+		NamePat:   regexp.MustCompile(`\.zip$`),
+		SHA256Pat: regexp.MustCompile(`^SHA256SUMS$`),
+	}
+	writeTestFile(t, filepath.Join(src, "SHA256SUMS"), sum+" *my vim.zip\n")
+	if _, _, err := fs.download(stdctx.Background(), out, &anchorInfo{}, nil); err != nil {
+		t.Fatalf("download should be verified by name with spaces: %s", err)
+	}
+
+	// configured checksum without entry for the archive.
+	writeTestFile(t, filepath.Join(src, "SHA256SUMS"), sum+"  other.zip\n")
+	if _, _, err := fs.download(stdctx.Background(), out, &anchorInfo{}, nil); err != errChecksumNotFound {
+		t.Errorf("download should fail without checksum: %v", err)
+	}
+}
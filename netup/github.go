@@ -0,0 +1,229 @@
+package netup
+
+import (
+	stdctx "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+// githubRelease is a release of GitHub.
+type githubRelease struct {
+	TagName     string        `json:"tag_name"`
+	Name        string        `json:"name"`
+	Draft       bool          `json:"draft"`
+	PreRelease  bool          `json:"prerelease"`
+	PublishedAt time.Time     `json:"published_at"`
+	Assets      []githubAsset `json:"assets"`
+}
+
+// githubAsset is an asset of GitHub's release.
+type githubAsset struct {
+	ID          int64     `json:"id"`
+	URL         string    `json:"url"`
+	Name        string    `json:"name"`
+	State       string    `json:"state"`
+	Size        int64     `json:"size"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	DownloadURL string    `json:"browser_download_url"`
+}
+
+// githubClient is a client for GitHub API.
+type githubClient struct {
+	baseURL string
+	token   string
+
+	// cacheDir is a directory to cache responses with ETag, to make
+	// conditional requests which don't count against rate limit.  No
+	// caches when empty.
+	cacheDir string
+}
+
+func (gc *githubClient) endpoint(format string, v ...interface{}) string {
+	base := gc.baseURL
+	if base == "" {
+		base = githubAPIURL
+	}
+	return strings.TrimRight(base, "/") + fmt.Sprintf(format, v...)
+}
+
+// get requests GitHub API and decodes response into v.  It returns
+// errSourceNotModified for "304 Not Modified" when pivot is not zero.
+func (gc *githubClient) get(ctx stdctx.Context, u string, pivot time.Time, v interface{}) error {
+	_, err := gc.getPage(ctx, u, pivot, v)
+	return err
+}
+
+// getPage is same as get, but returns URL of the next page by "Link" header,
+// or empty for the last page.
+func (gc *githubClient) getPage(ctx stdctx.Context, u string, pivot time.Time, v interface{}) (string, error) {
+	var next string
+	err := withRetry(ctx, "GET "+u, func() error {
+		var err error
+		next, err = gc.tryGet(ctx, u, pivot, v)
+		return err
+	})
+	return next, err
+}
+
+func (gc *githubClient) tryGet(ctx stdctx.Context, u string, pivot time.Time, v interface{}) (string, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if gc.token != "" {
+		req.Header.Set("Authorization", "token "+gc.token)
+	}
+	cached := gc.loadCache(u)
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.ETag)
+	} else if !pivot.IsZero() {
+		req.Header.Set("If-Modified-Since", pivot.UTC().Format(http.TimeFormat))
+	}
+	if GithubVerbose {
+		logInfo("github: GET %s", u)
+	}
+	client := http.Client{Timeout: downloadTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if GithubVerbose {
+		logInfo("github: %s", resp.Status)
+	}
+	rl := parseGithubRateLimit(resp)
+	if rl != nil {
+		logInfo("github: rate limit remaining %d/%d, reset at %s",
+			rl.remaining, rl.limit, rl.reset.Format(time.RFC3339))
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			return "", err
+		}
+		next := nextLink(resp.Header.Get("Link"))
+		gc.saveCache(u, resp.Header.Get("ETag"), next, b)
+		return next, nil
+	case http.StatusNotModified:
+		if cached != nil {
+			logInfo("github: use cached response for %s", u)
+			return cached.Next, json.Unmarshal(cached.Body, v)
+		}
+		return "", errSourceNotModified
+	default:
+		if rl != nil && rl.exhausted(resp) {
+			return "", gc.rateLimitError(resp, rl)
+		}
+		return "", githubError(resp)
+	}
+}
+
+var rxLinkNext = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="next"`)
+
+// nextLink extracts URL of the next page from "Link" header.
+func nextLink(h string) string {
+	m := rxLinkNext.FindStringSubmatch(h)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func githubError(resp *http.Response) error {
+	var msg struct {
+		Message string `json:"message"`
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(b, &msg) == nil && msg.Message != "" {
+		return newHTTPError(resp, fmt.Sprintf("github: %s: %s", resp.Status, msg.Message))
+	}
+	return newHTTPError(resp, fmt.Sprintf("github: unexpected response: %s", resp.Status))
+}
+
+// assetRequest returns URL and headers to download an asset.  API asset
+// endpoint is used when a token is set, so that assets of private repositories
+// can be downloaded.  It redirects to storage of assets, and the token isn't
+// sent there because it is other host.
+func (gc *githubClient) assetRequest(a *githubAsset) (string, http.Header) {
+	if gc.token == "" || a.URL == "" {
+		return a.DownloadURL, nil
+	}
+	return a.URL, http.Header{
+		"Accept":        {"application/octet-stream"},
+		"Authorization": {"token " + gc.token},
+	}
+}
+
+// latestRelease gets the latest release of a project.
+func (gc *githubClient) latestRelease(ctx stdctx.Context, user, project string, pivot time.Time) (*githubRelease, error) {
+	var r githubRelease
+	u := gc.endpoint("/repos/%s/%s/releases/latest",
+		url.PathEscape(user), url.PathEscape(project))
+	if err := gc.get(ctx, u, pivot, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// listReleases gets recent releases of a project, newest first.
+func (gc *githubClient) listReleases(ctx stdctx.Context, user, project string) ([]githubRelease, error) {
+	var rr []githubRelease
+	u := gc.endpoint("/repos/%s/%s/releases?per_page=100",
+		url.PathEscape(user), url.PathEscape(project))
+	if err := gc.get(ctx, u, time.Time{}, &rr); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// eachReleases calls f with pages of releases of a project, newest first,
+// until f returns false or all pages are read.
+func (gc *githubClient) eachReleases(ctx stdctx.Context, user, project string, f func([]githubRelease) bool) error {
+	u := gc.endpoint("/repos/%s/%s/releases?per_page=100",
+		url.PathEscape(user), url.PathEscape(project))
+	for u != "" {
+		var rr []githubRelease
+		next, err := gc.getPage(ctx, u, time.Time{}, &rr)
+		if err != nil {
+			return err
+		}
+		if !f(rr) {
+			break
+		}
+		u = next
+	}
+	return nil
+}
+
+// releaseByTag gets a release by its tag.  It returns nil when the tag isn't
+// found.
+func (gc *githubClient) releaseByTag(ctx stdctx.Context, user, project, tag string) (*githubRelease, error) {
+	var r githubRelease
+	u := gc.endpoint("/repos/%s/%s/releases/tags/%s",
+		url.PathEscape(user), url.PathEscape(project), url.PathEscape(tag))
+	if err := gc.get(ctx, u, time.Time{}, &r); err != nil {
+		if e, ok := err.(*httpError); ok && e.code == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// githubDefault is a client to access api.github.com.
+var githubDefault = &githubClient{}
@@ -0,0 +1,225 @@
+package netup
+
+import (
+	stdctx "context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGithubEnterpriseAsset(t *testing.T) {
+	var storageAuth string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageAuth = r.Header.Get("Authorization")
+		w.Write([]byte("archive"))
+	}))
+	defer storage.Close()
+	// use other host name to check the token isn't sent to storage.
+	storageURL := "http://localhost" + storage.URL[len("http://127.0.0.1"):]
+
+	var apiAuth []string
+	var api *httptest.Server
+	api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiAuth = append(apiAuth, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/v3/repos/tools/vim/releases/latest":
+			fmt.Fprintf(w, `{"tag_name":"v1","assets":[{"id":1,"name":"vim.zip","state":"uploaded",
+"url":"%[1]s/api/v3/repos/tools/vim/releases/assets/1",
+"browser_download_url":"%[1]s/tools/vim/releases/download/v1/vim.zip"}]}`, api.URL)
+		case "/api/v3/repos/tools/vim/releases/assets/1":
+			if r.Header.Get("Accept") != "application/octet-stream" {
+				http.Error(w, "wrong accept", http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, storageURL+"/vim.zip?signed", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gs := &GithubSource{
+		Name:    "vim",
+		User:    "tools",
+		Project: "vim",
+		NamePat: regexp.MustCompile(`^vim\.zip$`),
+		APIURL:  api.URL + "/api/v3",
+		Token:   "secret",
+	}
+	p, _, err := gs.download(stdctx.Background(), dir, &anchorInfo{}, nil)
+	if err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+	if p != filepath.Join(dir, "vim.zip") {
+		t.Errorf("archive should be saved with asset name: %s", p)
+	}
+	checkTestFile(t, p, "archive")
+	for _, a := range apiAuth {
+		if a != "token secret" {
+			t.Errorf("API should be requested with the token: %q", a)
+		}
+	}
+	if storageAuth != "" {
+		t.Errorf("token should not be sent to storage: %q", storageAuth)
+	}
+	if gs.id() == (&GithubSource{User: "tools", Project: "vim", NamePat: gs.NamePat}).id() {
+		t.Errorf("id should differ by API URL: %s", gs.id())
+	}
+}
+
+func TestGithubPreRelease(t *testing.T) {
+	const releases = `[
+{"tag_name":"v2-beta","prerelease":true,"published_at":"2019-03-01T00:00:00Z",
+ "assets":[{"name":"vim-v2-beta.zip","state":"uploaded","updated_at":"2019-03-01T00:00:00Z"}]},
+{"tag_name":"v3-draft","draft":true,"published_at":"2019-04-01T00:00:00Z",
+ "assets":[{"name":"vim-v3.zip","state":"uploaded","updated_at":"2019-04-01T00:00:00Z"}]},
+{"tag_name":"v1","published_at":"2019-02-01T00:00:00Z",
+ "assets":[{"name":"vim-v1.zip","state":"uploaded","updated_at":"2019-02-01T00:00:00Z"}]}
+]`
+	const latest = `{"tag_name":"v1","published_at":"2019-02-01T00:00:00Z",
+ "assets":[{"name":"vim-v1.zip","state":"uploaded","updated_at":"2019-02-01T00:00:00Z"}]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/vim/vim/releases":
+			w.Write([]byte(releases))
+		case "/repos/vim/vim/releases/latest":
+			w.Write([]byte(latest))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	gs := &GithubSource{
+		Name:       "vim",
+		User:       "vim",
+		Project:    "vim",
+		NamePat:    regexp.MustCompile(`^vim-.*\.zip$`),
+		APIURL:     ts.URL,
+		PreRelease: true,
+	}
+	ctx := stdctx.Background()
+
+	r, err := gs.check(ctx, &anchorInfo{})
+	if err != nil {
+		t.Fatalf("check failed: %s", err)
+	}
+	if r.name != "vim-v2-beta.zip" || !r.prerelease {
+		t.Errorf("newest pre-release should be chosen: %+v", r)
+	}
+	installed := &anchorInfo{
+		time:       time.Date(2019, 3, 2, 0, 0, 0, 0, time.UTC),
+		archive:    "vim-v2-beta.zip",
+		prerelease: true,
+	}
+	if _, err := gs.check(ctx, installed); err != errSourceNotModified {
+		t.Errorf("installed pre-release should be up to date: %v", err)
+	}
+
+	// back to releases from installed pre-release.
+	gs.PreRelease = false
+	r, err = gs.check(ctx, installed)
+	if err != nil {
+		t.Fatalf("check failed: %s", err)
+	}
+	if r.name != "vim-v1.zip" || r.prerelease {
+		t.Errorf("latest release should be chosen: %+v", r)
+	}
+}
+
+func TestGithubPinnedPages(t *testing.T) {
+	release := func(tag string) string {
+		return fmt.Sprintf(`{"tag_name":%[1]q,"assets":[{"name":"vim-%[1]s.zip","state":"uploaded"}]}`, tag)
+	}
+	pages := [][]string{
+		{release("v8.2.0002"), release("v8.2.0001")},
+		{release("v8.2.0000"), release("v8.1.0002")},
+		{release("v8.1.0001"), release("v8.0.0001")},
+		{release("v7.4.0001")},
+	}
+	var requested []string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path+"?"+r.URL.RawQuery)
+		switch r.URL.Path {
+		case "/repos/vim/vim/releases":
+			n := 0
+			fmt.Sscanf(r.URL.Query().Get("page"), "%d", &n)
+			if n+1 < len(pages) {
+				w.Header().Set("Link", fmt.Sprintf(`<%s/repos/vim/vim/releases?per_page=2&page=%d>; rel="next", <%[1]s/repos/vim/vim/releases?per_page=2&page=%d>; rel="last"`, ts.URL, n+1, len(pages)-1))
+			}
+			fmt.Fprintf(w, "[%s]", strings.Join(pages[n], ","))
+		case "/repos/vim/vim/releases/tags/v7.4.0001":
+			w.Write([]byte(release("v7.4.0001")))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	gs := &GithubSource{
+		Name:    "vim",
+		User:    "vim",
+		Project: "vim",
+		NamePat: regexp.MustCompile(`^vim-.*\.zip$`),
+		APIURL:  ts.URL,
+	}
+	ctx := stdctx.Background()
+	for _, tc := range []struct {
+		version  string
+		name     string
+		requests int
+	}{
+		// found in the second page.
+		{"~8.1", "vim-v8.1.0002.zip", 2},
+		// stops at the third page, which has older releases.
+		{">=8.0.0002 <8.1", "", 3},
+		// exact tag which isn't in the first page.
+		{"7.4.0001", "vim-v7.4.0001.zip", 3},
+		{"v7.3", "", 3},
+	} {
+		requested = nil
+		gs.Version = tc.version
+		r, err := gs.check(ctx, &anchorInfo{})
+		if tc.name == "" {
+			if err != errGithubNoPinned {
+				t.Errorf("version %q should not match: %v", tc.version, err)
+			}
+		} else if err != nil || r.name != tc.name {
+			t.Errorf("version %q should be resolved to %s: %+v %v", tc.version, tc.name, r, err)
+		}
+		if len(requested) != tc.requests {
+			t.Errorf("version %q should be resolved by %d requests: %v", tc.version, tc.requests, requested)
+		}
+	}
+}
+
+func TestAnchorPreRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "anchor.txt")
+	a := &anchorInfo{time: time.Now().Truncate(time.Second), archive: "vim.zip", prerelease: true}
+	if err := a.save(name); err != nil {
+		t.Fatal(err)
+	}
+	b, err := loadAnchor(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.prerelease || b.archive != "vim.zip" {
+		t.Errorf("prerelease should be loaded: %+v", b)
+	}
+}
@@ -0,0 +1,115 @@
+package netup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// githubCache is a cached response of GitHub API.
+type githubCache struct {
+	URL  string          `json:"url"`
+	ETag string          `json:"etag"`
+	Next string          `json:"next,omitempty"`
+	Body json.RawMessage `json:"body"`
+}
+
+func (gc *githubClient) cachePath(u string) string {
+	h := sha256.Sum256([]byte(u))
+	return filepath.Join(gc.cacheDir, hex.EncodeToString(h[:8])+".json")
+}
+
+// loadCache loads a cached response for URL.  It returns nil when not
+// cached.
+func (gc *githubClient) loadCache(u string) *githubCache {
+	if gc.cacheDir == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(gc.cachePath(u))
+	if err != nil {
+		return nil
+	}
+	c := &githubCache{}
+	if err := json.Unmarshal(b, c); err != nil || c.URL != u || c.ETag == "" {
+		return nil
+	}
+	return c
+}
+
+// saveCache saves a response for URL with its ETag and URL of the next page.
+// Failures are logged only, because the cache is optional.
+func (gc *githubClient) saveCache(u, etag, next string, body []byte) {
+	if gc.cacheDir == "" || etag == "" {
+		return
+	}
+	b, err := json.Marshal(&githubCache{URL: u, ETag: etag, Next: next, Body: body})
+	if err == nil {
+		err = os.MkdirAll(gc.cacheDir, 0777)
+	}
+	if err == nil {
+		name := gc.cachePath(u)
+		err = ioutil.WriteFile(name+".tmp", b, 0666)
+		if err == nil {
+			err = os.Rename(name+".tmp", name)
+		}
+	}
+	if err != nil {
+		logWarn("failed to cache github response: %s", err)
+	}
+}
+
+// githubRateLimit is rate limit status from "X-RateLimit-*" headers.
+type githubRateLimit struct {
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// parseGithubRateLimit parses rate limit headers.  It returns nil when the
+// response doesn't have them.
+func parseGithubRateLimit(resp *http.Response) *githubRateLimit {
+	h := resp.Header
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return nil
+	}
+	limit, _ := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	reset, _ := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	return &githubRateLimit{
+		limit:     limit,
+		remaining: remaining,
+		reset:     time.Unix(reset, 0),
+	}
+}
+
+// exhausted checks the response was rejected by rate limit.
+func (rl *githubRateLimit) exhausted(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		return rl.remaining == 0
+	}
+	return false
+}
+
+// rateLimitError returns an error which reports the reset time.  It is
+// retried when the reset comes soon.
+func (gc *githubClient) rateLimitError(resp *http.Response, rl *githubRateLimit) error {
+	msg := fmt.Sprintf("github: API rate limit exceeded (%d requests per hour), reset at %s",
+		rl.limit, rl.reset.Local().Format("15:04:05"))
+	if gc.token == "" {
+		msg += ", set github_token to raise the limit"
+	}
+	e := newHTTPError(resp, msg)
+	e.rateLimited = true
+	if d := time.Until(rl.reset) + time.Second; d > e.retryAfter {
+		e.retryAfter = d
+	}
+	return e
+}
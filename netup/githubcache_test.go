@@ -0,0 +1,96 @@
+package netup
+
+import (
+	stdctx "context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGithubCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		count       int
+		ifNoneMatch string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", "59")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		if ifNoneMatch == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"tag_name":"v1"}`))
+	}))
+	defer ts.Close()
+
+	gc := &githubClient{baseURL: ts.URL, cacheDir: dir}
+	for i := 0; i < 2; i++ {
+		r, err := gc.latestRelease(stdctx.Background(), "vim", "vim-win32-installer", time.Now())
+		if err != nil {
+			t.Fatalf("latestRelease #%d failed: %s", i, err)
+		}
+		if r.TagName != "v1" {
+			t.Errorf("unexpected release #%d: %+v", i, r)
+		}
+	}
+	if count != 2 || ifNoneMatch != `"v1"` {
+		t.Errorf("second request should be conditional by ETag: %d %q", count, ifNoneMatch)
+	}
+}
+
+func TestGithubRateLimit(t *testing.T) {
+	setRetryWait(t, 10*time.Millisecond)
+	var (
+		count int
+		reset time.Time
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if count == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"API rate limit exceeded"}`))
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "59")
+		w.Write([]byte(`{"tag_name":"v1"}`))
+	}))
+	defer ts.Close()
+	gc := &githubClient{baseURL: ts.URL}
+
+	// report reset time when it is far.
+	reset = time.Now().Add(time.Hour)
+	_, err := gc.latestRelease(stdctx.Background(), "vim", "vim", time.Time{})
+	if err == nil || !strings.Contains(err.Error(), "rate limit exceeded") || !strings.Contains(err.Error(), "github_token") {
+		t.Fatalf("should fail by rate limit: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("should not retry until far reset: %d", count)
+	}
+
+	// wait for reset when it comes soon.
+	count = 0
+	reset = time.Now()
+	if _, err := gc.latestRelease(stdctx.Background(), "vim", "vim", time.Time{}); err != nil {
+		t.Fatalf("should succeed after reset: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("should retry after reset: %d", count)
+	}
+}
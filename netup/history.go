@@ -0,0 +1,126 @@
+package netup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const historyInfoName = "info.txt"
+
+// generation is a committed transaction which is kept for rollback.
+type generation struct {
+	id   int
+	dir  string
+	info string
+}
+
+func (c *context) historyDir() string {
+	return filepath.Join(c.varDir, "history")
+}
+
+// listGenerations returns generations in dir, newest first.
+func listGenerations(dir string) ([]generation, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var gens []generation
+	for _, fi := range files {
+		id, err := strconv.Atoi(fi.Name())
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		g := generation{id: id, dir: filepath.Join(dir, fi.Name())}
+		if b, err := ioutil.ReadFile(filepath.Join(g.dir, historyInfoName)); err == nil {
+			g.info = strings.TrimSpace(string(b))
+		}
+		gens = append(gens, g)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i].id > gens[j].id })
+	return gens, nil
+}
+
+// prepareHistory writes information of the transaction, then returns a path
+// to keep it as a new generation.  It returns empty when history is disabled.
+func (c *context) prepareHistory(t *txn, zipName string) (string, error) {
+	if HistoryCount <= 0 {
+		return "", nil
+	}
+	gens, err := listGenerations(c.historyDir())
+	if err != nil {
+		return "", err
+	}
+	next := 1
+	if len(gens) > 0 {
+		next = gens[0].id + 1
+	}
+	info := fmt.Sprintf("%s %s\n", time.Now().Format(time.RFC3339), filepath.Base(zipName))
+	if err := ioutil.WriteFile(t.tempPath(historyInfoName), []byte(info), 0666); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(c.historyDir(), 0777); err != nil {
+		return "", err
+	}
+	return filepath.Join(c.historyDir(), fmt.Sprintf("%06d", next)), nil
+}
+
+// pruneHistory removes old generations over HistoryCount.
+func (c *context) pruneHistory() {
+	gens, err := listGenerations(c.historyDir())
+	if err != nil || len(gens) <= HistoryCount {
+		return
+	}
+	for _, g := range gens[HistoryCount:] {
+		if err := os.RemoveAll(g.dir); err != nil {
+			logWarn("failed to remove old generation %s: %s", g.dir, err)
+			continue
+		}
+		logInfo("removed old generation %s", g.dir)
+	}
+}
+
+// undoGeneration reverts changes of a generation then removes it.
+func (c *context) undoGeneration(g generation) error {
+	entries, err := loadJournal(filepath.Join(g.dir, txnJournalName))
+	if err != nil {
+		return err
+	}
+	relocateEntries(entries, c.txnDir(), g.dir)
+	logInfo("undo generation %d: %s", g.id, g.info)
+	if err := undoEntries(entries); err != nil {
+		return err
+	}
+	return os.RemoveAll(g.dir)
+}
+
+func rollback(c *context, n int) error {
+	gens, err := listGenerations(c.historyDir())
+	if err != nil {
+		return err
+	}
+	msgPrintln("installed generations (newest first):")
+	for i, g := range gens {
+		msgPrintf("  %d: %s\n", i+1, g.info)
+	}
+	if n < 1 || n > len(gens) {
+		return fmt.Errorf("no generations to rollback: %d (available %d)", n, len(gens))
+	}
+	for _, g := range gens[:n] {
+		msgPrintf("rollback %s\n", g.info)
+		if err := c.undoGeneration(g); err != nil {
+			return fmt.Errorf("failed to rollback generation %d: %s", g.id, err)
+		}
+	}
+	logInfo("rollback completed successfully")
+	msgPrintln("rollback completed")
+	return nil
+}
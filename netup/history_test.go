@@ -0,0 +1,80 @@
+package netup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestContext(t, dir, &DirectSource{Name: "vim", Strip: 1})
+	readState := func() (string, string) {
+		recipe, err := ioutil.ReadFile(c.recipePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		anchor, err := ioutil.ReadFile(c.anchorPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(recipe), string(anchor)
+	}
+
+	// first install.
+	zip1 := filepath.Join(c.tmpDir, "vim-1.zip")
+	writeTestZip(t, zip1, map[string]string{
+		"vim.exe":         "vim1",
+		"runtime/old.vim": "old",
+		"runtime/a.vim":   "a1",
+	})
+	if err := extract(c, zip1, &anchorInfo{time: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("extract #1 failed: %s", err)
+	}
+	recipe1, anchor1 := readState()
+
+	// second update.
+	zip2 := filepath.Join(c.tmpDir, "vim-2.zip")
+	writeTestZip(t, zip2, map[string]string{
+		"vim.exe":       "vim2",
+		"runtime/a.vim": "a2",
+		"runtime/b.vim": "b",
+	})
+	if err := extract(c, zip2, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract #2 failed: %s", err)
+	}
+	if recipe2, anchor2 := readState(); recipe2 == recipe1 || anchor2 == anchor1 {
+		t.Fatal("recipe and anchor should be updated")
+	}
+	gens, err := listGenerations(c.historyDir())
+	if err != nil || len(gens) != 2 {
+		t.Fatalf("two generations should be kept: %+v %v", gens, err)
+	}
+
+	if err := rollback(c, 1); err != nil {
+		t.Fatalf("rollback failed: %s", err)
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "vim1")
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "old.vim"), "old")
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "a.vim"), "a1")
+	for _, name := range []string{"vim.1.exe", "runtime/b.vim"} {
+		if _, err := os.Stat(filepath.Join(c.targetDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed by rollback: %v", name, err)
+		}
+	}
+	if recipe, anchor := readState(); recipe != recipe1 || anchor != anchor1 {
+		t.Errorf("recipe and anchor should be same with first install:\n%s\n%s", recipe, anchor)
+	}
+	if gens, _ := listGenerations(c.historyDir()); len(gens) != 1 {
+		t.Errorf("rolled back generation should be removed: %+v", gens)
+	}
+	if err := rollback(c, 2); err == nil {
+		t.Error("rollback over available generations should fail")
+	}
+}
@@ -0,0 +1,230 @@
+package netup
+
+import (
+	"bufio"
+	stdctx "context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// lockBrokenAge is age of a lock without valid content, which is treated
+	// as stale.  It may be being written by other process when younger.
+	lockBrokenAge = 10 * time.Second
+
+	lockPollInterval = 500 * time.Millisecond
+)
+
+// lockFile is an exclusive lock of work dir, to prevent concurrent runs.
+// Content of the file is lines like "key\tvalue": PID and the time when the
+// lock was acquired.
+type lockFile struct {
+	name string
+	pid  int
+	time time.Time
+}
+
+// acquireLock takes a lock.  It waits for other process to release the lock
+// until timeout, or fails immediately when timeout is zero.  A stale lock,
+// whose process is not running, is removed.  A lock without valid PID is
+// stale when it is old.
+func acquireLock(ctx stdctx.Context, name string, timeout time.Duration) (*lockFile, error) {
+	var deadline time.Time
+	for {
+		l, err := createLock(name)
+		if err == nil {
+			return l, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		held, err := readLock(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if held.stale() {
+			if err := removeStaleLock(name, held); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if deadline.IsZero() {
+			deadline = time.Now().Add(timeout)
+			if timeout > 0 {
+				logInfo("wait for lock of pid %d: %s", held.pid, name)
+				msgPrintf("waiting for other netupvim (pid %d) to finish...\n", held.pid)
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("other netupvim (pid %d) is running since %s, remove %s if it isn't",
+				held.pid, held.time.Format(time.RFC3339), name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// removeStaleLock removes a stale lock which was read as held.  The lock is
+// renamed to a unique name at first, and its content is checked again.  When
+// other waiter has replaced it with a fresh lock after held was read, the fresh
+// one is put back instead of removed.
+func removeStaleLock(name string, held *lockFile) error {
+	tmp := fmt.Sprintf("%s.%d.%d.stale", name, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(name, tmp); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(tmp)
+	l, err := readLock(tmp)
+	if err == nil && l.pid == held.pid && l.time.Equal(held.time) {
+		logWarn("remove stale lock of pid %d (since %s)", held.pid, held.time.Format(time.RFC3339))
+		return nil
+	}
+	// put back the fresh lock, unless another one was created meanwhile.
+	if err := os.Link(tmp, name); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// createLock creates a lock file exclusively.
+func createLock(name string) (*lockFile, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	l := &lockFile{name: name, pid: os.Getpid(), time: time.Now().Truncate(time.Second)}
+	_, err = fmt.Fprintf(f, "pid\t%d\ntime\t%s\n", l.pid, l.time.Format(time.RFC3339))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+	return l, nil
+}
+
+// readLock reads a lock file.  The time is modification time of the file when
+// the content is broken.
+func readLock(name string) (*lockFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	l := &lockFile{name: name}
+	r := bufio.NewReader(f)
+	for {
+		s, err := r.ReadString('\n')
+		if kv := strings.SplitN(strings.TrimSpace(s), "\t", 2); len(kv) == 2 {
+			switch kv[0] {
+			case "pid":
+				l.pid, _ = strconv.Atoi(kv[1])
+			case "time":
+				l.time, _ = time.Parse(time.RFC3339, kv[1])
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if l.pid <= 0 || l.time.IsZero() {
+		l.pid, l.time = 0, fi.ModTime()
+	}
+	return l, nil
+}
+
+// stale checks the process of the lock is not running.  A lock of running
+// process is never stale, even if it is old, because an update may take long
+// time over slow network.
+func (l *lockFile) stale() bool {
+	if l.pid == 0 {
+		return time.Since(l.time) > lockBrokenAge
+	}
+	return !processExists(l.pid)
+}
+
+// release removes the lock file, unless it was taken over by other process.
+func (l *lockFile) release() {
+	if l == nil {
+		return
+	}
+	if held, err := readLock(l.name); err != nil || held.pid != l.pid {
+		return
+	}
+	if err := os.Remove(l.name); err != nil {
+		logWarn("failed to remove lock: %s", err)
+	}
+}
+
+var (
+	runLocksMu sync.Mutex
+
+	// runLocks are locks of work dirs taken by LockWorkDir, which operations
+	// in the run don't take again.
+	runLocks = map[string]*lockFile{}
+)
+
+func runLockKey(name string) string {
+	if a, err := filepath.Abs(name); err == nil {
+		return a
+	}
+	return name
+}
+
+// LockWorkDir takes the lock of work dir for a whole run of operations, so
+// other runs can't get in between packages.  The returned function releases
+// the lock.
+func LockWorkDir(ctx stdctx.Context, workDir string) (func(), error) {
+	if err := os.MkdirAll(workDir, 0777); err != nil {
+		return nil, err
+	}
+	name := workDirLockPath(workDir)
+	l, err := acquireLock(ctx, name, LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	key := runLockKey(name)
+	runLocksMu.Lock()
+	runLocks[key] = l
+	runLocksMu.Unlock()
+	return func() {
+		runLocksMu.Lock()
+		delete(runLocks, key)
+		runLocksMu.Unlock()
+		l.release()
+	}, nil
+}
+
+// lockedByRun checks the lock is taken by LockWorkDir.
+func lockedByRun(name string) bool {
+	runLocksMu.Lock()
+	defer runLocksMu.Unlock()
+	_, ok := runLocks[runLockKey(name)]
+	return ok
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package netup
+
+import "syscall"
+
+// processExists checks a process is running, by sending signal 0.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,191 @@
+package netup
+
+import (
+	stdctx "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koron/go-arch"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "lock.txt")
+	ctx := stdctx.Background()
+
+	l, err := acquireLock(ctx, name, 0)
+	if err != nil {
+		t.Fatalf("acquireLock failed: %s", err)
+	}
+	if _, err := acquireLock(ctx, name, 0); err == nil || !strings.Contains(err.Error(), "is running") {
+		t.Fatalf("second lock should fail fast: %v", err)
+	}
+	start := time.Now()
+	if _, err := acquireLock(ctx, name, time.Second); err == nil {
+		t.Fatal("second lock should time out")
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("second lock should wait for timeout: %s", d)
+	}
+
+	// wait for release.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		l.release()
+	}()
+	l2, err := acquireLock(ctx, name, 10*time.Second)
+	if err != nil {
+		t.Fatalf("lock should be acquired after release: %s", err)
+	}
+	l2.release()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("lock file should be removed: %v", err)
+	}
+}
+
+func TestLockStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "lock.txt")
+	ctx := stdctx.Background()
+
+	// dead process.
+	content := "pid\t999999999\ntime\t" + time.Now().Format(time.RFC3339) + "\n"
+	if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	l, err := acquireLock(ctx, name, 0)
+	if err != nil {
+		t.Fatalf("stale lock should be removed: %q %s", content, err)
+	}
+	if l.pid != os.Getpid() {
+		t.Errorf("lock should be owned by this process: %d", l.pid)
+	}
+	l.release()
+
+	// old lock of running process is kept, for long downloads.
+	content = "pid\t" + strconv.Itoa(os.Getpid()) + "\ntime\t" + time.Now().Add(-24*time.Hour).Format(time.RFC3339) + "\n"
+	if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireLock(ctx, name, 0); err == nil {
+		t.Fatal("old lock of running process should be kept")
+	}
+	os.Remove(name)
+
+	// broken lock is kept while it is young.
+	if err := ioutil.WriteFile(name, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireLock(ctx, name, 0); err == nil {
+		t.Fatal("young broken lock should be kept")
+	}
+	old := time.Now().Add(-2 * lockBrokenAge)
+	os.Chtimes(name, old, old)
+	l, err = acquireLock(ctx, name, 0)
+	if err != nil {
+		t.Fatalf("old broken lock should be removed: %s", err)
+	}
+	l.release()
+}
+
+func TestLockStaleReplaced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "lock.txt")
+	content := "pid\t999999999\ntime\t" + time.Now().Format(time.RFC3339) + "\n"
+	if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	held, err := readLock(name)
+	if err != nil || !held.stale() {
+		t.Fatalf("lock should be stale: %+v %v", held, err)
+	}
+
+	// other waiter removed the stale lock and took a fresh one.
+	os.Remove(name)
+	l, err := createLock(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleLock(name, held); err != nil {
+		t.Fatalf("removeStaleLock failed: %s", err)
+	}
+	if l2, err := readLock(name); err != nil || l2.pid != l.pid || !l2.time.Equal(l.time) {
+		t.Fatalf("fresh lock should be kept: %+v %v", l2, err)
+	}
+	if _, err := acquireLock(stdctx.Background(), name, 0); err == nil {
+		t.Error("fresh lock should block others")
+	}
+	l.release()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("no files should be left: %d", len(files))
+	}
+}
+
+func TestLockCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "lock.txt")
+	l, err := acquireLock(stdctx.Background(), name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.release()
+	ctx, cancel := stdctx.WithTimeout(stdctx.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := acquireLock(ctx, name, time.Minute); err != stdctx.DeadlineExceeded {
+		t.Fatalf("waiting lock should be cancelled: %v", err)
+	}
+}
+
+func TestLockWorkDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, _ := setupPlanTest(t, dir)
+	defer func(d time.Duration) { LockTimeout = d }(LockTimeout)
+	LockTimeout = 0
+
+	unlock, err := LockWorkDir(stdctx.Background(), c.dataDir)
+	if err != nil {
+		t.Fatalf("LockWorkDir failed: %s", err)
+	}
+	// operations in the run don't take the lock again, nor release it.
+	pack := SourcePack{arch.X86: c.source}
+	for i := 0; i < 2; i++ {
+		if _, err := Check(stdctx.Background(), c.targetDir, c.dataDir, pack, Arch{Name: "x86"}); err != nil {
+			t.Fatalf("Check #%d failed: %s", i, err)
+		}
+		if _, err := os.Stat(c.lockPath()); err != nil {
+			t.Fatalf("lock should be kept between operations: %v", err)
+		}
+	}
+	if _, err := acquireLock(stdctx.Background(), c.lockPath(), 0); err == nil {
+		t.Error("other runs should be blocked")
+	}
+	unlock()
+	if _, err := os.Stat(c.lockPath()); !os.IsNotExist(err) {
+		t.Errorf("lock should be released: %v", err)
+	}
+}
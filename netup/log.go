@@ -24,25 +24,40 @@ func logInfo(format string, v ...interface{}) {
 // logWarn records a message to UI and logger file.
 func logWarn(format string, v ...interface{}) {
 	s := fmt.Sprintf(format, v...)
-	msgPrintln(s)
+	msgWarn(s)
 	logger.Println(s)
 }
 
 // logFatal records a message to UI and logger file then os.Exit(1)
 func logFatal(err error) {
-	msgPrintln(err)
+	msgError(err)
 	logger.Println(err)
-	os.Exit(1)
+	exit(1)
+}
+
+// exit reports exit code as an event, then exits.
+func exit(code int) {
+	msgEmit("exit", msgEvent{"code": code})
+	os.Exit(code)
 }
 
 func logLoadRecipeFailed(err error) {
-	if os.IsExist(err) {
+	if !os.IsNotExist(err) {
 		logWarn("failed to load recipe, try to extract all files: %s", err)
 	}
 }
 
-func logSaveRecipeFailed(err error) {
-	logWarn("failed to save recipe: %s", err)
+func logRollbackFailed(err error) {
+	logWarn("failed to rollback changes: %s", err)
+}
+
+func logCleanTxnFailed(err error) {
+	logWarn("failed to remove transaction data: %s", err)
+}
+
+func logConflict(name, owner string) {
+	msgFile(actionConflict, name)
+	logWarn("conflict: %s is owned by package %s, skipped", name, owner)
 }
 
 func logCompareFileFailed(err error, name string) {
@@ -53,6 +68,14 @@ func logCleanArchiveFailed(err error) {
 	logWarn("failed to remove downloaded archive: %s", err)
 }
 
+func logSavePartialFailed(err error) {
+	logWarn("failed to save partial download info: %s", err)
+}
+
+func logCleanPartialFailed(err error) {
+	logWarn("failed to remove stale partial file: %s", err)
+}
+
 func logCleanLogFailed(err error) {
 	logWarn("failed to remove old log file: %s", err)
 }
@@ -112,3 +135,15 @@ func LogInfo(format string, v ...interface{}) {
 func LogFatal(err error) {
 	logFatal(err)
 }
+
+// LogNotice records a message to UI and logger file.
+func LogNotice(format string, v ...interface{}) {
+	s := fmt.Sprintf(format, v...)
+	msgPrintln(s)
+	logger.Println(s)
+}
+
+// Exit reports exit code (as an event for JSON output), then exits.
+func Exit(code int) {
+	exit(code)
+}
@@ -0,0 +1,466 @@
+package netup
+
+import (
+	"bytes"
+	stdctx "context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koron/go-arch"
+)
+
+// mirrorEntry is a cached archive of a source for a CPU.  It is saved as
+// "release.json" beside the archive.
+type mirrorEntry struct {
+	Source  string `json:"source"`
+	CPU     string `json:"cpu"`
+	Release string `json:"release"`
+	Archive string `json:"archive"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+
+	// Updated is the time when the archive was cached.  It is used as
+	// Last-Modified for clients, and as anchor to poll the source.
+	Updated time.Time `json:"updated"`
+}
+
+// latestName returns an alias of the archive with its extension, like
+// "latest.tar.xz".
+func (e *mirrorEntry) latestName() string {
+	return "latest" + archiveExt(e.Archive)
+}
+
+// mirrorLatest is the alias of the archive, which clients use as URL.  It is
+// "latest.zip" for any format, to keep URLs of clients stable.  The format is
+// detected by magic bytes at extraction.
+const mirrorLatest = "latest.zip"
+
+// mirror caches archives of sources, and serves them over HTTP.  URLs are
+// "/{source}/{cpu}/latest.zip", "/{source}/{cpu}/latest.zip.sha256" and
+// "/{source}/{cpu}/release.json".
+type mirror struct {
+	dir      string
+	tmpDir   string
+	lockPath string
+	packs    map[string]SourcePack
+
+	mu      sync.RWMutex
+	entries map[string]*mirrorEntry
+}
+
+func mirrorCPU(cpu arch.CPU) string {
+	switch cpu {
+	case arch.X86:
+		return "x86"
+	case arch.AMD64:
+		return "amd64"
+	}
+	return ""
+}
+
+func mirrorKey(name, cpu string) string {
+	return name + "/" + cpu
+}
+
+func (m *mirror) entryDir(name, cpu string) string {
+	return filepath.Join(m.dir, name, cpu)
+}
+
+func (m *mirror) entry(key string) *mirrorEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.entries[key]
+}
+
+// load loads cached entries, to serve them before first poll.
+func (m *mirror) load() {
+	for name, pack := range m.packs {
+		for c := range pack {
+			cpu := mirrorCPU(c)
+			if cpu == "" {
+				continue
+			}
+			b, err := ioutil.ReadFile(filepath.Join(m.entryDir(name, cpu), "release.json"))
+			if err != nil {
+				continue
+			}
+			e := &mirrorEntry{}
+			if err := json.Unmarshal(b, e); err != nil {
+				logWarn("failed to load cached release of %s: %s", mirrorKey(name, cpu), err)
+				continue
+			}
+			m.entries[mirrorKey(name, cpu)] = e
+		}
+	}
+}
+
+// pollAll polls all sources once.  It takes the lock of work dir, because
+// tmp dir is shared with updates.
+func (m *mirror) pollAll(ctx stdctx.Context) {
+	l, err := acquireLock(ctx, m.lockPath, LockTimeout)
+	if err != nil {
+		logWarn("skip polling sources: %s", err)
+		return
+	}
+	defer l.release()
+	names := make([]string, 0, len(m.packs))
+	for name := range m.packs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for c, src := range m.packs[name] {
+			cpu := mirrorCPU(c)
+			if cpu == "" {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err := m.poll(ctx, name, cpu, src); err != nil {
+				logWarn("failed to update mirror of %s: %s", mirrorKey(name, cpu), err)
+			}
+		}
+	}
+	cleanPartials(m.tmpDir)
+}
+
+// poll downloads an update of a source, then replaces cached one.
+func (m *mirror) poll(ctx stdctx.Context, name, cpu string, src Source) error {
+	key := mirrorKey(name, cpu)
+	an := &anchorInfo{}
+	if e := m.entry(key); e != nil {
+		an.time, an.archive = e.Updated, e.Archive
+	}
+	r, err := src.check(ctx, an)
+	if err == errSourceNotModified {
+		logInfo("mirror of %s is up to date", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p, _, err := src.download(ctx, m.tmpDir, an, nil)
+	if err == errSourceNotModified {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sum, err := calcSHA256(p)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	dir := m.entryDir(name, cpu)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	e := &mirrorEntry{
+		Source:  name,
+		CPU:     cpu,
+		Release: r.name,
+		Archive: filepath.Base(p),
+		Size:    fi.Size(),
+		SHA256:  sum,
+		Updated: time.Now().Truncate(time.Second),
+	}
+	os.Remove(filepath.Join(dir, e.Archive))
+	if err := os.Rename(p, filepath.Join(dir, e.Archive)); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "release.json.tmp")
+	if err := ioutil.WriteFile(tmp, b, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "release.json")); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.entries[key] = e
+	m.mu.Unlock()
+	logInfo("mirror of %s is updated: %s", key, e.Archive)
+	msgEmit("mirror_updated", msgEvent{"source": name, "cpu": cpu, "archive": e.Archive})
+	m.clean(dir, e)
+	return nil
+}
+
+// clean removes old archives.  It may fail while clients download them, so
+// it is retried at next update.
+func (m *mirror) clean(dir string, e *mirrorEntry) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range files {
+		if fi.Name() == e.Archive || fi.Name() == "release.json" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			logWarn("failed to remove old archive: %s", err)
+		}
+	}
+}
+
+func (m *mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.Trim(path.Clean(r.URL.Path), "/")
+	if p == "" {
+		m.serveIndex(w, r)
+		return
+	}
+	f := strings.Split(p, "/")
+	if len(f) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	e := m.entry(mirrorKey(f[0], f[1]))
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch f[2] {
+	case e.Archive, e.latestName(), mirrorLatest:
+		m.serveArchive(w, r, e)
+	case e.Archive + ".sha256", e.latestName() + ".sha256", mirrorLatest + ".sha256":
+		s := fmt.Sprintf("%s  %s\n", e.SHA256, strings.TrimSuffix(f[2], ".sha256"))
+		m.serveBytes(w, r, e, "text/plain; charset=utf-8", []byte(s))
+	case "release.json":
+		b, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.serveBytes(w, r, e, "application/json", b)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *mirror) serveIndex(w http.ResponseWriter, r *http.Request) {
+	m.mu.RLock()
+	list := make([]*mirrorEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		list = append(list, e)
+	}
+	m.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return mirrorKey(list[i].Source, list[i].CPU) < mirrorKey(list[j].Source, list[j].CPU)
+	})
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// serveArchive serves an archive.  http.ServeContent handles
+// If-Modified-Since, If-None-Match and Range requests.
+func (m *mirror) serveArchive(w http.ResponseWriter, r *http.Request, e *mirrorEntry) {
+	f, err := os.Open(filepath.Join(m.entryDir(e.Source, e.CPU), e.Archive))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("ETag", `"`+e.SHA256+`"`)
+	http.ServeContent(w, r, e.Archive, e.Updated, f)
+	logInfo("mirror served %s to %s", r.URL.Path, r.RemoteAddr)
+}
+
+func (m *mirror) serveBytes(w http.ResponseWriter, r *http.Request, e *mirrorEntry, ctype string, b []byte) {
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("ETag", `"`+e.SHA256+`"`)
+	http.ServeContent(w, r, "", e.Updated, bytes.NewReader(b))
+}
+
+// Serve runs a caching mirror server of sources on addr.  Sources are polled
+// at every interval, and their archives are cached under workDir.  The server
+// is shut down when ctx is cancelled.
+func Serve(ctx stdctx.Context, workDir, addr string, packs map[string]SourcePack, interval time.Duration) error {
+	downloadTimeout = DownloadTimeout
+	githubDefault.token = GithubToken
+	githubDefault.cacheDir = filepath.Join(workDir, "cache", "github")
+
+	m := &mirror{
+		dir:      filepath.Join(workDir, "mirror"),
+		tmpDir:   filepath.Join(workDir, "tmp"),
+		lockPath: workDirLockPath(workDir),
+		packs:    packs,
+		entries:  map[string]*mirrorEntry{},
+	}
+	logDir := filepath.Join(workDir, "log")
+	for _, d := range []string{m.dir, m.tmpDir, logDir} {
+		if err := os.MkdirAll(d, 0777); err != nil {
+			return err
+		}
+	}
+	logSetup(logDir, LogRotateCount)
+	m.load()
+
+	go func() {
+		for {
+			m.pollAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	srv := &http.Server{Addr: addr, Handler: m}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	logInfo("mirror server listens on %s", addr)
+	msgPrintf("serving mirror on %s\n", addr)
+	err := srv.ListenAndServe()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// MirrorPack returns a source pack, which downloads archives of the source
+// from a mirror server of Serve.
+func MirrorPack(baseURL, source string, pack SourcePack) SourcePack {
+	mp := make(SourcePack, len(pack))
+	for c, src := range pack {
+		cpu := mirrorCPU(c)
+		if cpu == "" {
+			continue
+		}
+		mp[c] = &mirrorSource{
+			orig: src,
+			url:  strings.TrimSuffix(baseURL, "/") + "/" + source + "/" + cpu + "/",
+		}
+	}
+	return mp
+}
+
+// mirrorSource downloads archives of a source from a mirror server.  It has id
+// of the original source, so using a mirror isn't a switch of sources.  Names
+// of the release and the archive are taken from "release.json".
+type mirrorSource struct {
+	orig Source
+
+	// url is base URL of the source for a CPU, ends with "/".
+	url string
+}
+
+var _ Source = (*mirrorSource)(nil)
+
+func (ms *mirrorSource) download(ctx stdctx.Context, d string, an *anchorInfo, f progressFunc) (string, *release, error) {
+	e, err := fetchMirrorEntry(ctx, ms.url+"release.json", an.time)
+	if err != nil {
+		return "", nil, err
+	}
+	ds := &DirectSource{
+		Name:   ms.name(),
+		URL:    ms.url + mirrorLatest,
+		Strip:  ms.stripCount(),
+		SHA256: e.SHA256,
+	}
+	p, _, err := ds.download(ctx, d, an, f)
+	if err != nil {
+		return "", nil, err
+	}
+	// keep real name of the archive for anchor and history.
+	if name := filepath.Base(e.Archive); name != "." && name != ".." && name != string(filepath.Separator) {
+		np := filepath.Join(d, name)
+		if err := os.Rename(p, np); err != nil {
+			return "", nil, err
+		}
+		p = np
+	}
+	return p, &release{name: e.Release, updated: e.Updated}, nil
+}
+
+func (ms *mirrorSource) check(ctx stdctx.Context, an *anchorInfo) (*release, error) {
+	e, err := fetchMirrorEntry(ctx, ms.url+"release.json", an.time)
+	if err != nil {
+		return nil, err
+	}
+	if !an.time.IsZero() && !e.Updated.After(an.time) {
+		return nil, errSourceNotModified
+	}
+	return &release{name: e.Release, updated: e.Updated}, nil
+}
+
+func (ms *mirrorSource) stripCount() int {
+	return ms.orig.stripCount()
+}
+
+func (ms *mirrorSource) name() string {
+	return ms.orig.name()
+}
+
+func (ms *mirrorSource) id() string {
+	return ms.orig.id()
+}
+
+func (ms *mirrorSource) String() string {
+	return fmt.Sprintf("mirror: URL=%s (%s)", ms.url, ms.orig)
+}
+
+// fetchMirrorEntry fetches "release.json" of a mirror.  It returns
+// errSourceNotModified when the entry isn't updated after pivot.
+func fetchMirrorEntry(ctx stdctx.Context, inURL string, pivot time.Time) (*mirrorEntry, error) {
+	var e *mirrorEntry
+	err := withRetry(ctx, "fetch "+inURL, func() error {
+		var err error
+		e, err = tryFetchMirrorEntry(ctx, inURL, pivot)
+		return err
+	})
+	return e, err
+}
+
+func tryFetchMirrorEntry(ctx stdctx.Context, inURL string, pivot time.Time) (*mirrorEntry, error) {
+	req, err := http.NewRequest("GET", inURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !pivot.IsZero() {
+		req.Header.Set("If-Modified-Since", pivot.UTC().Format(http.TimeFormat))
+	}
+	client := http.Client{Timeout: downloadTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, errSourceNotModified
+	default:
+		return nil, newHTTPError(resp, fmt.Sprintf("unexpected response for release: %s", resp.Status))
+	}
+	e := &mirrorEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
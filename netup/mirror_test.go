@@ -0,0 +1,87 @@
+package netup
+
+import (
+	stdctx "context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/koron/go-arch"
+)
+
+func TestMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "upstream")
+	writeTestFile(t, filepath.Join(src, "vim-8.1.0001.zip"), "archive1")
+
+	pack := SourcePack{
+		arch.X86: &FileSource{
+			Name:    "vim",
+			Path:    src,
+			NamePat: regexp.MustCompile(`^vim-.*\.zip$`),
+			Strip:   1,
+		},
+	}
+	m := &mirror{
+		dir:      filepath.Join(dir, "mirror"),
+		tmpDir:   filepath.Join(dir, "tmp"),
+		lockPath: filepath.Join(dir, "lock.txt"),
+		packs:    map[string]SourcePack{"release": pack},
+		entries:  map[string]*mirrorEntry{},
+	}
+	os.MkdirAll(m.tmpDir, 0777)
+	m.pollAll(stdctx.Background())
+	e := m.entry("release/x86")
+	if e == nil || e.Archive != "vim-8.1.0001.zip" {
+		t.Fatalf("archive should be cached: %+v", e)
+	}
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	cp := MirrorPack(ts.URL, "release", pack)
+	ms := cp[arch.X86].(*mirrorSource)
+	// id of the original source is kept, not to switch sources of clients.
+	if ms.name() != "vim" || ms.stripCount() != 1 || ms.id() != pack[arch.X86].id() {
+		t.Errorf("unexpected mirror source: %+v", ms)
+	}
+
+	out := filepath.Join(dir, "out")
+	os.MkdirAll(out, 0777)
+	p, r, err := ms.download(stdctx.Background(), out, &anchorInfo{}, nil)
+	if err != nil {
+		t.Fatalf("download from mirror failed: %s", err)
+	}
+	checkTestFile(t, p, "archive1")
+	if filepath.Base(p) != "vim-8.1.0001.zip" || r.name == "" || r.name != e.Release {
+		t.Errorf("real names should be kept: %s %+v", p, r)
+	}
+
+	// not modified after installed.
+	an := &anchorInfo{time: e.Updated.Add(time.Second)}
+	if _, err := ms.check(stdctx.Background(), an); err != errSourceNotModified {
+		t.Errorf("check should return errSourceNotModified: %v", err)
+	}
+
+	// new archive in upstream replaces cached one.
+	writeTestFile(t, filepath.Join(src, "vim-8.1.0002.zip"), "archive2")
+	m.pollAll(stdctx.Background())
+	e2 := m.entry("release/x86")
+	if e2 == nil || e2.Archive != "vim-8.1.0002.zip" {
+		t.Fatalf("cached archive should be updated: %+v", e2)
+	}
+	an = &anchorInfo{time: e.Updated.Add(-time.Second)}
+	if r, err := ms.check(stdctx.Background(), an); err != nil || r.name != e2.Release {
+		t.Errorf("check should return new release: %+v %v", r, err)
+	}
+	if _, err := os.Stat(filepath.Join(m.dir, "release", "x86", "vim-8.1.0001.zip")); !os.IsNotExist(err) {
+		t.Errorf("old archive should be removed: %v", err)
+	}
+}
@@ -1,30 +1,160 @@
 package netup
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"time"
 )
 
+// OutputJSON switches all messages to JSON lines, one event per line.
+var OutputJSON bool
+
+// msgOut is a writer for JSON events.
+var msgOut io.Writer = os.Stdout
+
+// msgEvent is an event for JSON output.
+type msgEvent map[string]interface{}
+
+// msgEmit writes an event as a JSON line.  It does nothing in text mode.
+func msgEmit(kind string, ev msgEvent) {
+	if !OutputJSON {
+		return
+	}
+	if ev == nil {
+		ev = msgEvent{}
+	}
+	ev["event"] = kind
+	ev["time"] = time.Now().Format(time.RFC3339)
+	b, err := json.Marshal(ev)
+	if err != nil {
+		logger.Printf("failed to marshal event: %s", err)
+		return
+	}
+	msgOut.Write(append(b, '\n'))
+}
+
+// msgText emits free text as "message" event in JSON mode.
+func msgText(s string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return
+	}
+	msgEmit("message", msgEvent{"message": s})
+}
+
 func msgPrint(v ...interface{}) {
+	if OutputJSON {
+		msgText(fmt.Sprint(v...))
+		return
+	}
 	fmt.Print(v...)
 }
 
 func msgPrintln(v ...interface{}) {
+	if OutputJSON {
+		msgText(fmt.Sprintln(v...))
+		return
+	}
 	fmt.Println(v...)
 }
 
 func msgPrintf(format string, v ...interface{}) {
+	if OutputJSON {
+		msgText(fmt.Sprintf(format, v...))
+		return
+	}
 	fmt.Printf(format, v...)
 }
 
-func msgPrintProgress(percent int) {
+func msgWarn(s string) {
+	if OutputJSON {
+		msgEmit("warning", msgEvent{"message": s})
+		return
+	}
+	fmt.Println(s)
+}
+
+func msgError(err error) {
+	if OutputJSON {
+		msgEmit("error", msgEvent{"message": err.Error()})
+		return
+	}
+	fmt.Println(err)
+}
+
+func msgPrintProgress(phase string, percent int) {
 	if percent < 0 {
 		percent = 0
 	} else if percent > 100 {
 		percent = 100
 	}
+	if OutputJSON {
+		msgEmit("progress", msgEvent{"phase": phase, "percent": percent})
+		return
+	}
 	const col = 68
 	n := percent * col / 100
 	bar := strings.Repeat("=", n) + strings.Repeat(" ", col-n)
 	msgPrintf("\r    %3d%% |%s|", percent, bar)
 }
+
+// msgProgress shows progress of a phase, only when its percent is changed.
+type msgProgress struct {
+	phase string
+	last  int
+}
+
+func newMsgProgress(phase string) *msgProgress {
+	return &msgProgress{phase: phase, last: -1}
+}
+
+func (p *msgProgress) update(curr, max int64) {
+	if max <= 0 {
+		return
+	}
+	v := int(curr * 100 / max)
+	if v != p.last {
+		msgPrintProgress(p.phase, v)
+		p.last = v
+	}
+}
+
+// done terminates the progress bar.
+func (p *msgProgress) done() {
+	if !OutputJSON {
+		fmt.Println()
+	}
+}
+
+// msgFile reports an action for a file.  It is shown in JSON mode only.
+func msgFile(action planAction, name string) {
+	msgEmit("file", fileEvent(action, name))
+}
+
+func fileEvent(action planAction, name string) msgEvent {
+	return msgEvent{"action": action.String(), "path": name}
+}
+
+// msgSummary reports result of update for a package.
+func msgSummary(pkg string, counts map[planAction]int) {
+	if OutputJSON {
+		ev := msgEvent{"package": pkg}
+		for a, n := range counts {
+			ev[a.String()] = n
+		}
+		msgEmit("summary", ev)
+		return
+	}
+	var s []string
+	for a := actionAdd; a <= actionConflict; a++ {
+		if n := counts[a]; n > 0 && a != actionSkip {
+			s = append(s, fmt.Sprintf("%d %s", n, a))
+		}
+	}
+	if len(s) > 0 {
+		fmt.Printf("%s: %s\n", pkg, strings.Join(s, ", "))
+	}
+}
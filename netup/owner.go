@@ -0,0 +1,57 @@
+package netup
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// owners maps a path of file to name of package which installed it.
+type owners map[string]string
+
+func ownerKey(p string) string {
+	if a, err := filepath.Abs(p); err == nil {
+		p = a
+	}
+	if runtime.GOOS == "windows" {
+		p = strings.ToLower(p)
+	}
+	return p
+}
+
+// owner returns name of package which installed a file.
+func (o owners) owner(p string) (string, bool) {
+	name, ok := o[ownerKey(p)]
+	return name, ok
+}
+
+// loadOwners loads recipes of other packages in same work dir, to determine
+// owners of files.
+func (c *context) loadOwners() owners {
+	o := make(owners)
+	root := filepath.Dir(c.varDir)
+	self := filepath.Base(c.varDir)
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		return o
+	}
+	for _, fi := range files {
+		if !fi.IsDir() || fi.Name() == self {
+			continue
+		}
+		dir := filepath.Join(root, fi.Name())
+		t, err := loadFileInfo(filepath.Join(dir, "recipe.txt"))
+		if err != nil {
+			continue
+		}
+		target := filepath.Dir(c.dataDir)
+		if a, err := loadAnchor(filepath.Join(dir, "anchor.txt")); err == nil && a.target != "" {
+			target = a.target
+		}
+		for name := range t {
+			o[ownerKey(filepath.Join(target, name))] = fi.Name()
+		}
+	}
+	return o
+}
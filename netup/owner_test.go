@@ -0,0 +1,127 @@
+package netup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOwnerConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vim := newTestContext(t, dir, &DirectSource{Name: "vim", Strip: 1})
+	plugin := newTestContext(t, dir, &DirectSource{Name: "plugin", Strip: 1})
+	zipName := filepath.Join(vim.tmpDir, "vim.zip")
+	writeTestZip(t, zipName, map[string]string{
+		"vim.exe":        "vim",
+		"plugin/foo.vim": "vim's foo",
+	})
+	if err := extract(vim, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+
+	// files owned by other package are reported as conflict, and skipped.
+	zipName = filepath.Join(plugin.tmpDir, "plugin.zip")
+	writeTestZip(t, zipName, map[string]string{
+		"plugin/foo.vim": "plugin's foo",
+		"plugin/bar.vim": "plugin's bar",
+	})
+	pl, err := makePlan(plugin, zipName, time.Now())
+	if err != nil {
+		t.Fatalf("makePlan failed: %s", err)
+	}
+	actions := make(map[string]planEntry)
+	for _, e := range pl.entries {
+		actions[e.name] = e
+	}
+	if e := actions["plugin/foo.vim"]; e.action != actionConflict || e.owner != "vim" {
+		t.Errorf("owned file should be conflict: %+v", e)
+	}
+	if e := actions["plugin/bar.vim"]; e.action != actionAdd {
+		t.Errorf("new file should be added: %+v", e)
+	}
+	if err := extract(plugin, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	checkTestFile(t, filepath.Join(vim.targetDir, "plugin", "foo.vim"), "vim's foo")
+	checkTestFile(t, filepath.Join(vim.targetDir, "plugin", "bar.vim"), "plugin's bar")
+	rc, err := loadRecipe(plugin.recipePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rc.files["plugin/foo.vim"]; ok {
+		t.Error("conflicted file should not be recorded")
+	}
+}
+
+func TestOwnerCleanFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vim := newTestContext(t, dir, &DirectSource{Name: "vim", Strip: 1})
+	plugin := newTestContext(t, dir, &DirectSource{Name: "plugin", Strip: 1})
+	files := map[string]string{
+		"plugin/common.vim": "common",
+		"plugin/bar.vim":    "bar",
+	}
+	zipName := filepath.Join(plugin.tmpDir, "plugin.zip")
+	writeTestZip(t, zipName, files)
+	if err := extract(plugin, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+
+	// both recipes list common.vim, like an installation by old version
+	// which didn't check owners.
+	hidden := filepath.Join(dir, "hidden")
+	if err := os.Rename(plugin.varDir, hidden); err != nil {
+		t.Fatal(err)
+	}
+	zipName = filepath.Join(vim.tmpDir, "vim.zip")
+	writeTestZip(t, zipName, map[string]string{
+		"vim.exe":           "vim",
+		"plugin/common.vim": "common",
+	})
+	if err := extract(vim, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	if err := os.Rename(hidden, plugin.varDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file isn't removed by other package, which doesn't use it anymore.
+	delete(files, "plugin/common.vim")
+	delete(files, "plugin/bar.vim")
+	files["plugin/baz.vim"] = "baz"
+	zipName = filepath.Join(plugin.tmpDir, "plugin.zip")
+	writeTestZip(t, zipName, files)
+	pl, err := makePlan(plugin, zipName, time.Now())
+	if err != nil {
+		t.Fatalf("makePlan failed: %s", err)
+	}
+	var removed []string
+	for _, e := range pl.entries {
+		if e.action == actionRemove {
+			removed = append(removed, e.target)
+		}
+	}
+	if len(removed) != 1 || removed[0] != filepath.Join(plugin.targetDir, "plugin", "bar.vim") {
+		t.Errorf("only unused file of the package should be removed: %v", removed)
+	}
+	if err := extract(plugin, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	checkTestFile(t, filepath.Join(vim.targetDir, "plugin", "common.vim"), "common")
+	if _, err := os.Stat(filepath.Join(vim.targetDir, "plugin", "bar.vim")); !os.IsNotExist(err) {
+		t.Errorf("unused file should be removed: %v", err)
+	}
+	if ok, err := verify(vim); err != nil || !ok {
+		t.Errorf("other package should be kept: %t %v", ok, err)
+	}
+}
@@ -0,0 +1,51 @@
+package netup
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// parallelEach calls fn for each index in [0, n) by bounded number of
+// workers.  After the first error, workers stop to take new indexes, then the
+// error is returned.
+func parallelEach(n, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	var (
+		next     int64 = -1
+		stop           = make(chan struct{})
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				if err := fn(i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(stop)
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,40 @@
+package netup
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelEach(t *testing.T) {
+	done := make([]bool, 100)
+	err := parallelEach(len(done), 8, func(i int) error {
+		done[i] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("parallelEach failed: %s", err)
+	}
+	for i, ok := range done {
+		if !ok {
+			t.Errorf("index %d is not processed", i)
+		}
+	}
+
+	// an error stops other workers.
+	var count int64
+	errTest := errors.New("test")
+	err = parallelEach(1000, 4, func(i int) error {
+		atomic.AddInt64(&count, 1)
+		if i == 10 {
+			return errTest
+		}
+		return nil
+	})
+	if err != errTest {
+		t.Errorf("parallelEach should return the error: %v", err)
+	}
+	if n := atomic.LoadInt64(&count); n >= 1000 {
+		t.Errorf("workers should stop after the error: %d calls", n)
+	}
+}
@@ -0,0 +1,178 @@
+package netup
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	partialSuffix     = ".part"
+	partialInfoSuffix = ".part.info"
+)
+
+// partialExpiration is age of partial files which be treated as stale.
+var partialExpiration = 7 * 24 * time.Hour
+
+// partialInfo holds validators of a partially downloaded file.
+type partialInfo struct {
+	ETag         string
+	LastModified string
+
+	// Length is expected total length of the file, -1 for unknown.
+	Length int64
+}
+
+func newPartialInfo(resp *http.Response) *partialInfo {
+	return &partialInfo{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Length:       resp.ContentLength,
+	}
+}
+
+func partialPath(outPath string) string {
+	return outPath + partialSuffix
+}
+
+func partialInfoPath(outPath string) string {
+	return outPath + partialInfoSuffix
+}
+
+func loadPartialInfo(outPath string) (*partialInfo, error) {
+	f, err := os.Open(partialInfoPath(outPath))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := textproto.NewReader(bufio.NewReader(f)).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	pi := &partialInfo{
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+		Length:       -1,
+	}
+	if s := h.Get("Content-Length"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		pi.Length = n
+	}
+	return pi, nil
+}
+
+func (pi *partialInfo) save(outPath string) error {
+	f, err := os.Create(partialInfoPath(outPath))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if pi.ETag != "" {
+		fmt.Fprintf(w, "ETag: %s\r\n", pi.ETag)
+	}
+	if pi.LastModified != "" {
+		fmt.Fprintf(w, "Last-Modified: %s\r\n", pi.LastModified)
+	}
+	if pi.Length >= 0 {
+		fmt.Fprintf(w, "Content-Length: %d\r\n", pi.Length)
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// validator returns a value for "If-Range" header.  Weak ETag can't be used
+// for it.
+func (pi *partialInfo) validator() string {
+	if pi.ETag != "" && !strings.HasPrefix(pi.ETag, "W/") {
+		return pi.ETag
+	}
+	return pi.LastModified
+}
+
+// resumePoint returns validators and size of partial file for outPath.  It
+// returns nil and zero when the download can't be resumed.
+func resumePoint(outPath string) (*partialInfo, int64) {
+	pi, err := loadPartialInfo(outPath)
+	if err != nil || pi.validator() == "" {
+		return nil, 0
+	}
+	fi, err := os.Stat(partialPath(outPath))
+	if err != nil || fi.Size() == 0 {
+		return nil, 0
+	}
+	if pi.Length >= 0 && fi.Size() >= pi.Length {
+		return nil, 0
+	}
+	return pi, fi.Size()
+}
+
+// parseContentRange parses "Content-Range" header, returns first byte
+// position and complete length (-1 for unknown).
+func parseContentRange(s string) (start, total int64, err error) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+	n := strings.IndexByte(s, '/')
+	m := strings.IndexByte(s, '-')
+	if n < 0 || m < 0 || m > n {
+		return 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	start, err = strconv.ParseInt(s[:m], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if s[n+1:] == "*" {
+		return start, -1, nil
+	}
+	total, err = strconv.ParseInt(s[n+1:], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, total, nil
+}
+
+func removePartial(outPath string) {
+	os.Remove(partialPath(outPath))
+	os.Remove(partialInfoPath(outPath))
+}
+
+// cleanPartials removes stale partial files in dir.
+func cleanPartials(dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if !strings.HasSuffix(name, partialSuffix) &&
+			!strings.HasSuffix(name, partialInfoSuffix) {
+			continue
+		}
+		if time.Since(fi.ModTime()) < partialExpiration {
+			continue
+		}
+		p := filepath.Join(dir, name)
+		if err := os.Remove(p); err != nil {
+			logCleanPartialFailed(err)
+			continue
+		}
+		logInfo("remove stale partial file %s", p)
+	}
+}
@@ -0,0 +1,95 @@
+package netup
+
+import (
+	"bytes"
+	stdctx "context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var lastRange string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastRange = r.Header.Get("Range")
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "a.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "a.zip")
+
+	// prepare a partial file as an interrupted download.
+	if err := ioutil.WriteFile(partialPath(out), content[:300], 0666); err != nil {
+		t.Fatal(err)
+	}
+	pi := &partialInfo{ETag: `"abc"`, Length: int64(len(content))}
+	if err := pi.save(out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := downloadAsFile(stdctx.Background(), ts.URL+"/a.zip", out, nil, time.Time{}, nil); err != nil {
+		t.Fatalf("downloadAsFile failed: %s", err)
+	}
+	if lastRange != "bytes=300-" {
+		t.Errorf("download should be resumed: Range=%q", lastRange)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("downloaded content mismatch: %d bytes", len(b))
+	}
+	if _, err := os.Stat(partialPath(out)); !os.IsNotExist(err) {
+		t.Errorf("partial file should be removed: %v", err)
+	}
+	if _, err := os.Stat(partialInfoPath(out)); !os.IsNotExist(err) {
+		t.Errorf("partial info should be removed: %v", err)
+	}
+}
+
+func TestDownloadRangeIgnored(t *testing.T) {
+	content := bytes.Repeat([]byte("abcdefghij"), 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"new"`)
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "a.zip")
+
+	if err := ioutil.WriteFile(partialPath(out), []byte("stale data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pi := &partialInfo{ETag: `"old"`, Length: int64(len(content))}
+	if err := pi.save(out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := downloadAsFile(stdctx.Background(), ts.URL+"/a.zip", out, nil, time.Time{}, nil); err != nil {
+		t.Fatalf("downloadAsFile failed: %s", err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("downloaded content mismatch: %q", b)
+	}
+}
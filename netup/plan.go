@@ -0,0 +1,304 @@
+package netup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errPlanMismatch = errors.New("plan doesn't match current archive or recipe")
+
+type planAction int
+
+const (
+	actionAdd planAction = iota + 1
+	actionOverwrite
+	actionSkip
+	actionEvacuate
+	actionRotate
+	actionRemove
+	actionConflict
+)
+
+var planActionNames = map[planAction]string{
+	actionAdd:       "add",
+	actionOverwrite: "overwrite",
+	actionSkip:      "skip",
+	actionEvacuate:  "evacuate",
+	actionRotate:    "rotate",
+	actionRemove:    "remove",
+	actionConflict:  "conflict",
+}
+
+func (a planAction) String() string {
+	return planActionNames[a]
+}
+
+func parsePlanAction(s string) (planAction, error) {
+	for k, v := range planActionNames {
+		if v == s {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown plan action: %q", s)
+}
+
+// planEntry is an action for a file.
+type planEntry struct {
+	action planAction
+	name   string
+	target string
+	rotate bool
+
+	// owner is name of other package which installed the file, for
+	// actionConflict.
+	owner string
+}
+
+// updatePlan describes changes which will be done by extracting an archive.
+type updatePlan struct {
+	target  string
+	archive string
+	anchor  time.Time
+
+	// prerelease is true when the archive is a pre-release.
+	prerelease bool
+
+	// archiveSum and recipeSum are SHA-256 of the archive and the recipe when
+	// the plan is made.  recipeSum is empty when no recipes.
+	archiveSum string
+	recipeSum  string
+
+	entries []planEntry
+}
+
+func fileSHA256(name string) (string, error) {
+	s, err := calcSHA256(name)
+	if err != nil && os.IsNotExist(err) {
+		return "", nil
+	}
+	return s, err
+}
+
+// makePlan makes a plan to extract an archive.  It doesn't write anything to
+// target dir.
+func makePlan(c *context, zipName string, anchor time.Time) (*updatePlan, error) {
+	prev, err := loadFileInfo(c.recipePath())
+	if err != nil {
+		logLoadRecipeFailed(err)
+		prev = make(fileInfoTable)
+	}
+	a, err := openArchive(zipName, c.tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	pl := &updatePlan{
+		target:  c.targetDir,
+		archive: zipName,
+		anchor:  anchor,
+	}
+	pr := newPlanner(c, prev)
+	files, err := archiveFiles(a, pr.stripCount, pr.dir)
+	if err != nil {
+		return nil, err
+	}
+	curr := make(fileInfoTable)
+	for _, r := range pr.planFiles(files) {
+		fi, e := r.fi, r.e
+		if e.action != actionConflict {
+			curr[fi.name] = fi
+		}
+		if e.rotate && e.action != actionSkip {
+			pl.entries = append(pl.entries, planEntry{
+				action: actionRotate,
+				name:   e.name,
+				target: e.target,
+			})
+		}
+		pl.entries = append(pl.entries, e)
+	}
+	unused := unusedFiles(c.targetDir, prev, curr, pr.owners)
+	sort.Strings(unused)
+	for _, fpath := range unused {
+		pl.entries = append(pl.entries, planEntry{
+			action: actionRemove,
+			target: fpath,
+		})
+	}
+	if pl.archiveSum, err = calcSHA256(zipName); err != nil {
+		return nil, err
+	}
+	if pl.recipeSum, err = fileSHA256(c.recipePath()); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// print shows the plan.  Skipped files are shown as a count only.
+func (pl *updatePlan) print() {
+	counts := make(map[planAction]int)
+	msgPrintf("plan for archive %s\n", pl.archive)
+	for _, e := range pl.entries {
+		counts[e.action]++
+		switch e.action {
+		case actionSkip:
+			continue
+		case actionConflict:
+			msgPrintf("  %-9s %s (owned by %s)\n", e.action, e.target, e.owner)
+			continue
+		}
+		msgPrintf("  %-9s %s\n", e.action, e.target)
+	}
+	msgPrintln("summary:")
+	for a := actionAdd; a <= actionConflict; a++ {
+		msgPrintf("  %-9s %d\n", a, counts[a])
+	}
+}
+
+func (pl *updatePlan) save(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	q := strconv.Quote
+	fmt.Fprintf(w, "target\t%s\n", q(pl.target))
+	fmt.Fprintf(w, "archive\t%s\t%s\n", q(pl.archive), q(pl.archiveSum))
+	fmt.Fprintf(w, "recipe\t%s\n", q(pl.recipeSum))
+	fmt.Fprintf(w, "anchor\t%s\n", q(pl.anchor.Format(time.RFC3339)))
+	if pl.prerelease {
+		fmt.Fprintf(w, "prerelease\t%s\n", q("true"))
+	}
+	for _, e := range pl.entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.action, q(e.name), q(e.target))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func loadPlan(name string) (*updatePlan, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pl := &updatePlan{}
+	r := bufio.NewReader(f)
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		s := strings.Split(strings.TrimSuffix(l, "\n"), "\t")
+		v := make([]string, len(s)-1)
+		for i, q := range s[1:] {
+			if v[i], err = strconv.Unquote(q); err != nil {
+				return nil, fmt.Errorf("broken plan: %q", l)
+			}
+		}
+		switch {
+		case s[0] == "target" && len(v) == 1:
+			pl.target = v[0]
+		case s[0] == "archive" && len(v) == 2:
+			pl.archive, pl.archiveSum = v[0], v[1]
+		case s[0] == "recipe" && len(v) == 1:
+			pl.recipeSum = v[0]
+		case s[0] == "anchor" && len(v) == 1:
+			if pl.anchor, err = time.Parse(time.RFC3339, v[0]); err != nil {
+				return nil, err
+			}
+		case s[0] == "prerelease" && len(v) == 1:
+			pl.prerelease = v[0] == "true"
+		case len(v) == 2:
+			a, err := parsePlanAction(s[0])
+			if err != nil {
+				return nil, err
+			}
+			pl.entries = append(pl.entries, planEntry{action: a, name: v[0], target: v[1]})
+		default:
+			return nil, fmt.Errorf("broken plan: %q", l)
+		}
+	}
+	return pl, nil
+}
+
+// dryRun downloads an archive and shows a plan to extract it, without
+// changing target dir.  The plan is saved to planFile if it isn't empty.
+// The downloaded archive is kept for applyPlan.
+func dryRun(c *context, planFile string) error {
+	src := c.source
+	logInfo("determined source: %s", src.String())
+	a, err := loadAnchorFor(c)
+	if err != nil {
+		return err
+	}
+	mp := newMsgProgress("download")
+	p, r, err := src.download(c.ctx, c.tmpDir, a, mp.update)
+	mp.done()
+	if err != nil {
+		if err == errSourceNotModified {
+			logInfo("no updates found")
+			msgEmit("not_modified", msgEvent{"package": src.name()})
+			msgPrintln("no updates found, nothing to do")
+			err = nil
+		}
+		return err
+	}
+	pl, err := makePlan(c, p, time.Now())
+	if err != nil {
+		return err
+	}
+	pl.prerelease = r.prerelease
+	pl.print()
+	if planFile != "" {
+		if err := pl.save(planFile); err != nil {
+			return err
+		}
+		logInfo("saved plan to %s", planFile)
+		msgPrintf("saved plan to %s\n", planFile)
+	}
+	return nil
+}
+
+// applyPlan extracts an archive of the plan, only when the archive and the
+// recipe are same with when the plan was made.
+func applyPlan(c *context, planFile string) error {
+	pl, err := loadPlan(planFile)
+	if err != nil {
+		return err
+	}
+	if pl.target != c.targetDir {
+		return fmt.Errorf("plan is for other target: %s", pl.target)
+	}
+	archiveSum, err := fileSHA256(pl.archive)
+	if err != nil {
+		return err
+	}
+	recipeSum, err := fileSHA256(c.recipePath())
+	if err != nil {
+		return err
+	}
+	if archiveSum != pl.archiveSum || recipeSum != pl.recipeSum {
+		return errPlanMismatch
+	}
+	logInfo("apply plan %s", planFile)
+	na := &anchorInfo{time: pl.anchor, prerelease: pl.prerelease}
+	if err := extract(c, pl.archive, na); err != nil {
+		return err
+	}
+	c.cacheArchive(pl.archive)
+	return nil
+}
@@ -0,0 +1,190 @@
+package netup
+
+import (
+	stdctx "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/koron/go-arch"
+)
+
+// setupPlanTest installs first archive, and prepares second one as a source.
+func setupPlanTest(t *testing.T, dir string) (*context, string) {
+	share := filepath.Join(dir, "share", "vim.zip")
+	if err := os.MkdirAll(filepath.Dir(share), 0777); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestContext(t, dir, &FileSource{Name: "vim", Path: share, Strip: 1})
+	zipName := filepath.Join(c.tmpDir, "vim.zip")
+	writeTestZip(t, zipName, map[string]string{
+		"vim.exe":         "vim1",
+		"runtime/old.vim": "old",
+		"runtime/a.vim":   "a",
+	})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	writeTestZip(t, share, map[string]string{
+		"vim.exe":       "vim2",
+		"runtime/a.vim": "a",
+		"runtime/b.vim": "b",
+	})
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(share, future, future)
+	return c, filepath.Join(dir, "plan.txt")
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, planFile := setupPlanTest(t, dir)
+
+	if err := dryRun(c, planFile); err != nil {
+		t.Fatalf("dryRun failed: %s", err)
+	}
+	// nothing is changed by dry run.
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "vim1")
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "old.vim"), "old")
+	if _, err := os.Stat(filepath.Join(c.targetDir, "runtime", "b.vim")); !os.IsNotExist(err) {
+		t.Errorf("b.vim should not be extracted by dry run: %v", err)
+	}
+
+	pl, err := loadPlan(planFile)
+	if err != nil {
+		t.Fatalf("loadPlan failed: %s", err)
+	}
+	if pl.target != c.targetDir || pl.archiveSum == "" || pl.recipeSum == "" || pl.anchor.IsZero() {
+		t.Errorf("unexpected plan: %+v", pl)
+	}
+	got := make(map[string]planAction)
+	for _, e := range pl.entries {
+		got[e.target] = e.action
+	}
+	exe := filepath.Join(c.targetDir, "vim.exe")
+	want := map[string]planAction{
+		exe: actionOverwrite,
+		filepath.Join(c.targetDir, "runtime", "a.vim"):   actionSkip,
+		filepath.Join(c.targetDir, "runtime", "b.vim"):   actionAdd,
+		filepath.Join(c.targetDir, "runtime", "old.vim"): actionRemove,
+	}
+	// rotation of vim.exe is recorded before overwrite.
+	rotated := false
+	for i, e := range pl.entries {
+		if e.target == exe && e.action == actionOverwrite {
+			rotated = i > 0 && pl.entries[i-1].action == actionRotate && pl.entries[i-1].target == exe
+		}
+	}
+	if len(pl.entries) != len(want)+1 || !rotated {
+		t.Errorf("unexpected entries: %+v", pl.entries)
+	}
+	delete(got, exe)
+	delete(want, exe)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected actions: %v", got)
+	}
+
+	// save and load again.
+	if err := pl.save(planFile + ".2"); err != nil {
+		t.Fatal(err)
+	}
+	pl2, err := loadPlan(planFile + ".2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pl, pl2) {
+		t.Errorf("plan should be same after round trip:\n%+v\n%+v", pl, pl2)
+	}
+
+	if err := applyPlan(c, planFile); err != nil {
+		t.Fatalf("applyPlan failed: %s", err)
+	}
+	checkTestFile(t, exe, "vim2")
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.1.exe"), "vim1")
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "b.vim"), "b")
+	if _, err := os.Stat(filepath.Join(c.targetDir, "runtime", "old.vim")); !os.IsNotExist(err) {
+		t.Errorf("old.vim should be removed: %v", err)
+	}
+	a, err := loadAnchor(c.anchorPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.time.Equal(pl.anchor) {
+		t.Errorf("anchor should be the time of plan: %s", a.time)
+	}
+}
+
+func TestPlanStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, planFile := setupPlanTest(t, dir)
+	if err := dryRun(c, planFile); err != nil {
+		t.Fatalf("dryRun failed: %s", err)
+	}
+	recipe, err := ioutil.ReadFile(c.recipePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// recipe was changed by other update.
+	rc, err := loadRecipe(c.recipePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(rc.files, "runtime/old.vim")
+	if err := rc.save(c.recipePath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPlan(c, planFile); err != errPlanMismatch {
+		t.Errorf("applyPlan should refuse stale recipe: %v", err)
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "vim1")
+
+	// archive was changed.
+	if err := ioutil.WriteFile(c.recipePath(), recipe, 0666); err != nil {
+		t.Fatal(err)
+	}
+	pl, err := loadPlan(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestZip(t, pl.archive, map[string]string{"vim.exe": "vim3"})
+	if err := applyPlan(c, planFile); err != errPlanMismatch {
+		t.Errorf("applyPlan should refuse changed archive: %v", err)
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "vim1")
+}
+
+func TestDryRunKeepsPendingTxn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, planFile := setupPlanTest(t, dir)
+	journal := filepath.Join(c.txnDir(), txnJournalName)
+	writeTestFile(t, journal, "")
+	recipe, err := ioutil.ReadFile(c.recipePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack := SourcePack{arch.X86: c.source}
+	err = DryRun(stdctx.Background(), c.targetDir, c.dataDir, pack, Arch{Name: "x86"}, planFile)
+	if err != nil {
+		t.Fatalf("DryRun failed: %s", err)
+	}
+	if _, err := os.Stat(journal); err != nil {
+		t.Errorf("pending transaction should be kept by dry run: %v", err)
+	}
+	checkTestFile(t, c.recipePath(), string(recipe))
+}
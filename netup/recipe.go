@@ -0,0 +1,208 @@
+package netup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// recipeVersion is version of recipe format.  Version 1 is legacy format,
+// which has lines of "{name}\t{size}\t{crc32}" only.
+const recipeVersion = 2
+
+// recipe is content of recipe file: files which installed by a package, and
+// id of the source and the archive which they came from.  Version 2 format is
+// like below:
+//
+//	version	2
+//	source	"{source}"
+//	release	"{archive}"
+//	file	"{name}"	{size}	{crc32}	{sha256}
+type recipe struct {
+	version int
+	source  string
+	release string
+	files   fileInfoTable
+}
+
+// loadRecipe loads a recipe file.  Legacy format is loaded as version 1.
+func loadRecipe(name string) (*recipe, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rc := &recipe{version: 1, files: make(fileInfoTable)}
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		l, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if l = strings.TrimRight(l, "\r\n"); l != "" {
+			var perr error
+			if n == 1 && strings.HasPrefix(l, "version\t") {
+				rc.version, perr = strconv.Atoi(l[len("version\t"):])
+				if perr == nil && rc.version > recipeVersion {
+					perr = fmt.Errorf("unsupported version %d", rc.version)
+				}
+			} else if rc.version == 1 {
+				perr = rc.parseLegacy(l)
+			} else {
+				perr = rc.parse(l)
+			}
+			if perr != nil {
+				return nil, fmt.Errorf("invalid recipe at %s:%d: %s", name, n, perr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return rc, nil
+}
+
+// parseLegacy parses a line of version 1.  Name may contain spaces.
+func (rc *recipe) parseLegacy(l string) error {
+	f := strings.Split(l, "\t")
+	n := len(f)
+	if n < 3 {
+		return fmt.Errorf("too few fields")
+	}
+	fi := fileInfo{name: strings.Join(f[:n-2], "\t")}
+	if err := fi.parseSizeHash(f[n-2], f[n-1]); err != nil {
+		return err
+	}
+	rc.files[fi.name] = fi
+	return nil
+}
+
+func (rc *recipe) parse(l string) error {
+	f := strings.Split(l, "\t")
+	switch f[0] {
+	case "source", "release":
+		if len(f) != 2 {
+			return fmt.Errorf("wrong number of fields for %s", f[0])
+		}
+		s, err := strconv.Unquote(f[1])
+		if err != nil {
+			return err
+		}
+		if f[0] == "source" {
+			rc.source = s
+		} else {
+			rc.release = s
+		}
+	case "file":
+		if len(f) != 5 {
+			return fmt.Errorf("wrong number of fields for file")
+		}
+		name, err := strconv.Unquote(f[1])
+		if err != nil {
+			return err
+		}
+		fi := fileInfo{name: name, sha256: f[4]}
+		if err := fi.parseSizeHash(f[2], f[3]); err != nil {
+			return err
+		}
+		rc.files[fi.name] = fi
+	}
+	// ignore unknown keys for compatibility.
+	return nil
+}
+
+// save writes a recipe file in current version atomically.
+func (rc *recipe) save(name string) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = rc.write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (rc *recipe) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "version\t%d\n", recipeVersion)
+	fmt.Fprintf(bw, "source\t%s\n", strconv.Quote(rc.source))
+	fmt.Fprintf(bw, "release\t%s\n", strconv.Quote(rc.release))
+	names := make([]string, 0, len(rc.files))
+	for name := range rc.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fi := rc.files[name]
+		fmt.Fprintf(bw, "file\t%s\t%d\t%08x\t%s\n",
+			strconv.Quote(fi.name), fi.size, fi.hash, fi.sha256)
+	}
+	return bw.Flush()
+}
+
+// loadFileInfo loads files from a recipe file.
+func loadFileInfo(name string) (fileInfoTable, error) {
+	rc, err := loadRecipe(name)
+	if err != nil {
+		return nil, err
+	}
+	return rc.files, nil
+}
+
+// migrateRecipe rewrites a recipe file of old version in current version.
+// The source and the release are recorded, and SHA-256 of files which are
+// not modified in dir are calculated.  Modified files are left empty until
+// they are extracted again.
+func migrateRecipe(name, dir string, src Source, release string) error {
+	rc, err := loadRecipe(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if rc.version >= recipeVersion {
+		return nil
+	}
+	logInfo("migrate recipe from version %d: %s", rc.version, name)
+	rc.source = src.id()
+	rc.release = release
+	for k, fi := range rc.files {
+		fi.sha256 = fillSHA256(fi, filepath.Join(dir, fi.name))
+		rc.files[k] = fi
+	}
+	return rc.save(name)
+}
+
+// fillSHA256 returns SHA-256 of a file recorded in recipe.  It is calculated
+// from the installed file when it isn't recorded yet, and the file isn't
+// modified.
+func fillSHA256(fi fileInfo, path string) string {
+	if fi.sha256 != "" {
+		return fi.sha256
+	}
+	if r, err := fi.compareWithFile(path); err != nil || r != fileIsMatch {
+		return ""
+	}
+	sum, err := calcSHA256(path)
+	if err != nil {
+		logWarn("failed to calculate SHA-256 of %s: %s", path, err)
+		return ""
+	}
+	return sum
+}
@@ -0,0 +1,122 @@
+package netup
+
+import (
+	stdctx "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koron/go-arch"
+)
+
+func TestRecipeMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "recipe.txt")
+	writeTestFile(t, name, "vim.exe\t100\t0000abcd\nMy Documents/a b.txt\t3\t12345678\n")
+
+	src := &DirectSource{URL: "https://example.com/vim.zip"}
+	if err := migrateRecipe(name, dir, src, "vim.zip"); err != nil {
+		t.Fatalf("migrateRecipe failed: %s", err)
+	}
+	rc, err := loadRecipe(name)
+	if err != nil {
+		t.Fatalf("loadRecipe failed: %s", err)
+	}
+	if rc.version != recipeVersion || rc.source != src.id() || rc.release != "vim.zip" {
+		t.Errorf("unexpected recipe: %+v", rc)
+	}
+	if fi := rc.files["My Documents/a b.txt"]; fi.size != 3 || fi.hash != 0x12345678 {
+		t.Errorf("name with spaces should be kept: %+v", rc.files)
+	}
+
+	// round trip with escaped names and SHA-256.
+	rc.files["tab\tname"] = fileInfo{name: "tab\tname", size: 1, hash: 1, sha256: "ab"}
+	if err := rc.save(name); err != nil {
+		t.Fatalf("save failed: %s", err)
+	}
+	rc2, err := loadRecipe(name)
+	if err != nil {
+		t.Fatalf("loadRecipe failed: %s", err)
+	}
+	if len(rc2.files) != 3 || rc2.files["tab\tname"].sha256 != "ab" {
+		t.Errorf("unexpected files: %+v", rc2.files)
+	}
+
+	writeTestFile(t, name, "broken\n")
+	if _, err := loadRecipe(name); err == nil {
+		t.Errorf("loadRecipe should fail for broken recipe")
+	}
+}
+
+func TestRecipeMigrateOnWriteOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+	work := filepath.Join(target, "netupvim")
+	name := filepath.Join(work, "var", "vim", "recipe.txt")
+	const legacy = "vim.exe\t3\t280100fb\n"
+	writeTestFile(t, name, legacy)
+	writeTestFile(t, filepath.Join(target, "vim.exe"), "vim")
+	pack := SourcePack{arch.X86: &DirectSource{Name: "vim", URL: "https://example.com/vim.zip"}}
+	ctx := stdctx.Background()
+
+	if _, err := Verify(ctx, target, work, pack, Arch{Name: "x86"}); err != nil {
+		t.Fatalf("Verify failed: %s", err)
+	}
+	checkTestFile(t, name, legacy)
+
+	if err := Repair(ctx, target, work, pack, Arch{Name: "x86"}); err != nil {
+		t.Fatalf("Repair failed: %s", err)
+	}
+	rc, err := loadRecipe(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.version != recipeVersion {
+		t.Errorf("recipe should be migrated by write operation: %+v", rc)
+	}
+	if fi := rc.files["vim.exe"]; fi.sha256 != "0f2ed9e33d29ff4f3b0f664ca1e1dc3df1f8b9b315b2af284c6e0e3dc52be290" {
+		t.Errorf("SHA-256 should be filled by migration: %+v", fi)
+	}
+}
+
+func TestRecipeFillSHA256(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestContext(t, dir, &DirectSource{Name: "vim", URL: "https://example.com/vim.zip", Strip: 1})
+	zipName := filepath.Join(c.tmpDir, "vim.zip")
+	writeTestZip(t, zipName, map[string]string{"vim.exe": "vim", "a.txt": "a"})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	// recipe of old version without SHA-256, and a modified file.
+	writeTestFile(t, c.recipePath(), "vim.exe\t3\t280100fb\na.txt\t1\te8b7be43\n")
+	writeTestFile(t, filepath.Join(c.targetDir, "a.txt"), "b")
+
+	// skipped files get SHA-256 too.
+	writeTestZip(t, zipName, map[string]string{"vim.exe": "vim", "a.txt": "a"})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	rc, err := loadRecipe(c.recipePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, fi := range rc.files {
+		if fi.sha256 == "" {
+			t.Errorf("SHA-256 of %s should be filled: %+v", name, fi)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package netup
+
+import (
+	stdctx "context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// retryMaxWait is upper limit of wait before a retry.  Requests which the
+// server asks to wait longer by "Retry-After" are not retried.
+const retryMaxWait = time.Minute
+
+// httpError is an error for unexpected HTTP response.
+type httpError struct {
+	code int
+	msg  string
+
+	// retryAfter is a delay which the server asked by "Retry-After".
+	retryAfter time.Duration
+
+	// rateLimited is true when the request was rejected by rate limit.
+	rateLimited bool
+}
+
+func newHTTPError(resp *http.Response, msg string) *httpError {
+	return &httpError{
+		code:       resp.StatusCode,
+		msg:        msg,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+// parseRetryAfter parses "Retry-After" header, which is seconds or HTTP date.
+func parseRetryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryable checks an error is transient: server errors, "429 Too Many
+// Requests", rate limit and network errors.  Other 4xx and errors like
+// errSourceNotModified are not retried.
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *httpError:
+		return e.code == http.StatusTooManyRequests || e.code >= 500 || e.rateLimited
+	case *url.Error:
+		return isNetError(e.Err)
+	}
+	return isNetError(err)
+}
+
+func isNetError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// retryDelay returns a wait before n-th retry (from 0): exponential backoff
+// with jitter, up to retryMaxWait.  "Retry-After" is used when it is longer.
+func retryDelay(err error, n int) time.Duration {
+	base := RetryWait << uint(n)
+	if base <= 0 || base > retryMaxWait {
+		base = retryMaxWait
+	}
+	d := base/2 + time.Duration(rand.Int63n(int64(base)+1))
+	if d > retryMaxWait {
+		d = retryMaxWait
+	}
+	if e, ok := err.(*httpError); ok && e.retryAfter > d {
+		d = e.retryAfter
+	}
+	return d
+}
+
+// withRetry calls f until it succeeds, up to RetryCount retries for
+// transient errors.
+func withRetry(ctx stdctx.Context, what string, f func() error) error {
+	for n := 0; ; n++ {
+		err := f()
+		if err == nil || ctx.Err() != nil || n >= RetryCount || !retryable(err) {
+			return err
+		}
+		if e, ok := err.(*httpError); ok && e.retryAfter > retryMaxWait {
+			logInfo("%s failed: %s, server asks to retry after %s", what, err, e.retryAfter)
+			return err
+		}
+		d := retryDelay(err, n)
+		logWarn("%s failed (attempt %d/%d): %s, retry after %s",
+			what, n+1, RetryCount+1, err, d.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
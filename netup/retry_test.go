@@ -0,0 +1,152 @@
+package netup
+
+import (
+	"bytes"
+	stdctx "context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func setRetryWait(t *testing.T, d time.Duration) {
+	old := RetryWait
+	RetryWait = d
+	t.Cleanup(func() { RetryWait = old })
+}
+
+func TestDownloadRetry(t *testing.T) {
+	setRetryWait(t, 10*time.Millisecond)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var (
+		count     int
+		lastRange string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		lastRange = r.Header.Get("Range")
+		switch count {
+		case 1:
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		case 2:
+			// reset connection in the middle of body.
+			w.Header().Set("ETag", `"abc"`)
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:300])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		default:
+			w.Header().Set("ETag", `"abc"`)
+			http.ServeContent(w, r, "a.zip", time.Time{}, bytes.NewReader(content))
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "a.zip")
+	if err := downloadAsFile(stdctx.Background(), ts.URL+"/a.zip", out, nil, time.Time{}, nil); err != nil {
+		t.Fatalf("download should succeed by retries: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("should be requested 3 times: %d", count)
+	}
+	if lastRange != "bytes=300-" {
+		t.Errorf("last retry should resume partial download: %q", lastRange)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("downloaded content mismatch")
+	}
+}
+
+func TestRetryClassify(t *testing.T) {
+	setRetryWait(t, 10*time.Millisecond)
+	for _, tc := range []struct {
+		code  int
+		count int
+	}{
+		{http.StatusNotFound, 1},
+		{http.StatusForbidden, 1},
+		{http.StatusNotModified, 1},
+		{http.StatusTooManyRequests, RetryCount + 1},
+		{http.StatusServiceUnavailable, RetryCount + 1},
+	} {
+		var count int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count++
+			w.WriteHeader(tc.code)
+		}))
+		err := githubDefault.get(stdctx.Background(), ts.URL, time.Time{}, &githubRelease{})
+		ts.Close()
+		if err == nil {
+			t.Errorf("%d should fail", tc.code)
+		}
+		if count != tc.count {
+			t.Errorf("%d should be requested %d times: %d", tc.code, tc.count, count)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	setRetryWait(t, 30*time.Second)
+	for i := 0; i < 100; i++ {
+		if d := retryDelay(io.EOF, 10); d < retryMaxWait/2 || d > retryMaxWait {
+			t.Fatalf("delay should be clamped to retryMaxWait: %s", d)
+		}
+	}
+	err := &httpError{code: http.StatusTooManyRequests, retryAfter: retryMaxWait + time.Second}
+	if d := retryDelay(err, 10); d != err.retryAfter {
+		t.Errorf("delay should be Retry-After: %s", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	setRetryWait(t, 10*time.Millisecond)
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if count == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"tag_name":"v1"}`))
+	}))
+	defer ts.Close()
+	start := time.Now()
+	var r githubRelease
+	if err := githubDefault.get(stdctx.Background(), ts.URL, time.Time{}, &r); err != nil {
+		t.Fatalf("get should succeed by a retry: %s", err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("retry should wait for Retry-After: %s", d)
+	}
+	if r.TagName != "v1" {
+		t.Errorf("unexpected release: %+v", r)
+	}
+
+	// too long Retry-After isn't waited.
+	count = 0
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts2.Close()
+	if err := githubDefault.get(stdctx.Background(), ts2.URL, time.Time{}, &r); err == nil || count != 1 {
+		t.Errorf("long Retry-After should fail immediately: %v %d", err, count)
+	}
+}
@@ -1,107 +1,180 @@
 package netup
 
 import (
-	"fmt"
-	"os"
 	"path/filepath"
 	"time"
 )
 
-func saveFileInfo(fname string, t fileInfoTable) error {
-	f, err := os.Create(fname)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	for _, v := range t {
-		_, err := fmt.Fprintf(f, fileInfoFormat, v.name, v.size, v.hash)
-		if err != nil {
-			return err
-		}
-	}
-	return f.Sync()
-}
-
-// cleanFiles removes unused/untracked files.
-func cleanFiles(dir string, prev, curr fileInfoTable) {
+// unusedFiles returns paths of unused files, which are recorded in prev but
+// not in curr, and not modified nor owned by other packages.
+func unusedFiles(dir string, prev, curr fileInfoTable, o owners) []string {
+	var files []string
 	for _, p := range prev {
 		if _, ok := curr[p.name]; ok {
 			continue
 		}
 		fpath := filepath.Join(dir, p.name)
+		if owner, ok := o.owner(fpath); ok {
+			logInfo("keep unused file %s, owned by %s", fpath, owner)
+			continue
+		}
 		if r, _ := p.compareWithFile(fpath); r != fileIsMatch {
 			continue
 		}
-		os.Remove(fpath)
+		files = append(files, fpath)
+	}
+	return files
+}
+
+// cleanFiles removes unused/untracked files, returns number of removed files.
+func cleanFiles(t *txn, dir string, prev, curr fileInfoTable, o owners) (int, error) {
+	files := unusedFiles(dir, prev, curr, o)
+	for _, fpath := range files {
+		if err := t.remove(fpath); err != nil {
+			return 0, err
+		}
+		t.msgFile(actionRemove, fpath)
 		logInfo("remove unused file %s", fpath)
 	}
+	return len(files), nil
 }
 
-func extract(zipName, dir string, stripCount int, recipeName string) error {
-	prev, err := loadFileInfo(recipeName)
+// extract extracts an archive into target dir as a transaction.  Recipe and
+// anchor are updated in same transaction.  anchor has the time and the kind
+// of the release, and other properties are filled.
+func extract(c *context, zipName string, anchor *anchorInfo) error {
+	prev, err := loadFileInfo(c.recipePath())
 	if err != nil {
 		logLoadRecipeFailed(err)
 		prev = make(fileInfoTable)
 	}
-	logInfo("extract archive: %s", zipName)
-	msgPrintf("extract archive\n")
-	last := -1
-	curr, err := extractZip(zipName, dir, stripCount, prev, func(curr, max uint64) {
-		v := int(curr * 100 / max)
-		if v != last {
-			msgPrintProgress(v)
-			last = v
-		}
-	})
-	msgPrintln()
+	t, err := beginTxn(c.txnDir())
 	if err != nil {
 		return err
 	}
-	if err := saveFileInfo(recipeName, curr); err != nil {
-		logSaveRecipeFailed(err)
+	counts, err := extractTxn(c, t, zipName, prev, anchor)
+	if err != nil {
+		if err2 := t.rollback(); err2 != nil {
+			logRollbackFailed(err2)
+		}
+		return err
+	}
+	keep, err := c.prepareHistory(t, zipName)
+	if err != nil {
+		logWarn("failed to prepare history: %s", err)
+		keep = ""
+	}
+	if err := t.commit(keep); err != nil {
+		return err
 	}
-	cleanFiles(dir, prev, curr)
+	c.pruneHistory()
 	logInfo("extract completed successfully")
+	msgSummary(c.source.name(), counts)
 	return nil
 }
 
+func extractTxn(c *context, t *txn, zipName string, prev fileInfoTable, anchor *anchorInfo) (map[planAction]int, error) {
+	logInfo("extract archive: %s", zipName)
+	msgPrintf("extract archive\n")
+	pl := newPlanner(c, prev)
+	mp := newMsgProgress("extract")
+	curr, staged, err := extractArchive(c.ctx, zipName, pl, t, func(curr, max uint64) {
+		mp.update(int64(curr), int64(max))
+	})
+	mp.done()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[planAction]int)
+	if err := applyStagedFiles(c.ctx, t, staged, counts); err != nil {
+		return nil, err
+	}
+	rc := &recipe{
+		source:  c.source.id(),
+		release: filepath.Base(zipName),
+		files:   curr,
+	}
+	err = t.writeFile(c.recipePath(), rc.save)
+	if err != nil {
+		return nil, err
+	}
+	n, err := cleanFiles(t, c.targetDir, prev, curr, pl.owners)
+	if err != nil {
+		return nil, err
+	}
+	counts[actionRemove] = n
+	target, err := filepath.Abs(c.targetDir)
+	if err != nil {
+		return nil, err
+	}
+	a := &anchorInfo{
+		time:       anchor.time,
+		archive:    filepath.Base(zipName),
+		target:     target,
+		source:     c.source.id(),
+		prerelease: anchor.prerelease,
+	}
+	if err := t.writeFile(c.anchorPath(), a.save); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
 func update(c *context) error {
 	src := c.source
 	logInfo("determined source: %s", src.String())
-	t, err := c.anchor()
+	a, err := loadAnchorFor(c)
 	if err != nil {
 		return err
 	}
-	last := -1
-	p, err := src.download(c.tmpDir, t, func(curr, max int64) {
-		v := int(curr * 100 / max)
-		if v != last {
-			msgPrintProgress(v)
-			last = v
-		}
-	})
-	msgPrintln()
+	mp := newMsgProgress("download")
+	p, r, err := src.download(c.ctx, c.tmpDir, a, mp.update)
+	mp.done()
 	if err != nil {
 		if err == errSourceNotModified {
 			logInfo("no updates found")
+			msgEmit("not_modified", msgEvent{"package": src.name()})
 			err = nil
 		}
 		return err
 	}
 	logInfo("download completed successfully")
 	// capture anchor's new value.
-	t = time.Now()
-	if err := extract(p, c.targetDir, src.stripCount(), c.recipePath()); err != nil {
+	na := &anchorInfo{time: time.Now(), prerelease: r.prerelease}
+	if err := extract(c, p, na); err != nil {
 		return err
 	}
-	if err := c.updateAnchor(t); err != nil {
-		c.resetAnchor()
-		return err
+	c.cacheArchive(p)
+	return nil
+}
+
+// check checks an update is available or not, without downloading.
+func check(c *context) (bool, error) {
+	src := c.source
+	logInfo("determined source: %s", src.String())
+	a, err := loadAnchorFor(c)
+	if err != nil {
+		return false, err
 	}
-	if err := os.Remove(p); err != nil {
-		logCleanArchiveFailed(err)
+	msgPrintf("current:   %s\n", a)
+	r, err := src.check(c.ctx, a)
+	if err != nil {
+		if err == errSourceNotModified {
+			logInfo("no updates found")
+			msgEmit("not_modified", msgEvent{"package": src.name()})
+			msgPrintln("up to date")
+			return false, nil
+		}
+		return false, err
 	}
-	return nil
+	logInfo("found an update: %s", r)
+	msgEmit("update_available", msgEvent{
+		"package":    src.name(),
+		"release":    r.name,
+		"prerelease": r.prerelease,
+	})
+	msgPrintf("available: %s\n", r)
+	return true, nil
 }
 
 func restore(c *context) error {
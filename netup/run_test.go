@@ -0,0 +1,211 @@
+package netup
+
+import (
+	"bytes"
+	stdctx "context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koron/go-arch"
+)
+
+func TestSourceSwitch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestContext(t, dir, &DirectSource{Name: "vim", URL: "https://example.com/release.zip", Strip: 1})
+	zipName := filepath.Join(c.tmpDir, "release.zip")
+	writeTestZip(t, zipName, map[string]string{
+		"vim.exe":      "release",
+		"release.txt":  "only in release",
+		"runtime/a.vm": "common",
+	})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	a, err := loadAnchorFor(c)
+	if err != nil || a.time.IsZero() {
+		t.Fatalf("anchor should be kept for same source: %+v %v", a, err)
+	}
+
+	// switch to other source.
+	c.source = &DirectSource{Name: "vim", URL: "https://example.com/canary.zip", Strip: 1}
+	a, err = loadAnchorFor(c)
+	if err != nil || !a.time.IsZero() {
+		t.Fatalf("anchor should be empty after source was changed: %+v %v", a, err)
+	}
+	zipName = filepath.Join(c.tmpDir, "canary.zip")
+	writeTestZip(t, zipName, map[string]string{
+		"vim.exe":      "canary",
+		"runtime/a.vm": "common",
+	})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "canary")
+	if _, err := os.Stat(filepath.Join(c.targetDir, "release.txt")); !os.IsNotExist(err) {
+		t.Errorf("file of old distribution should be removed: %v", err)
+	}
+	if a, _ := loadAnchor(c.anchorPath()); a.source != c.source.id() {
+		t.Errorf("anchor should record new source: %q", a.source)
+	}
+}
+
+func TestExtractCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestContext(t, dir, &DirectSource{Name: "vim", URL: "https://example.com/release.zip", Strip: 1})
+	zipName := filepath.Join(c.tmpDir, "release.zip")
+	writeTestZip(t, zipName, map[string]string{"vim.exe": "old"})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+
+	ctx, cancel := stdctx.WithCancel(stdctx.Background())
+	cancel()
+	c.ctx = ctx
+	zipName = filepath.Join(c.tmpDir, "release2.zip")
+	writeTestZip(t, zipName, map[string]string{"vim.exe": "new", "new.txt": "new"})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != stdctx.Canceled {
+		t.Fatalf("extract should be cancelled: %v", err)
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "old")
+	if _, err := os.Stat(filepath.Join(c.targetDir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("file should be rolled back: %v", err)
+	}
+	if a, _ := loadAnchor(c.anchorPath()); a.archive != "release.zip" {
+		t.Errorf("anchor should be kept: %q", a.archive)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	defer func(b bool, w io.Writer) {
+		OutputJSON, msgOut = b, w
+	}(OutputJSON, msgOut)
+	var out bytes.Buffer
+	OutputJSON, msgOut = true, &out
+	installed := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+		update  bool
+		methods string
+	}{
+		{"not modified", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-Modified-Since") != installed.Format(http.TimeFormat) {
+				t.Errorf("unexpected If-Modified-Since: %s", r.Header.Get("If-Modified-Since"))
+			}
+			w.WriteHeader(http.StatusNotModified)
+		}, false, "HEAD"},
+		{"same last modified", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Last-Modified", installed.Format(http.TimeFormat))
+		}, false, "HEAD"},
+		{"newer last modified", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Last-Modified", installed.Add(time.Hour).Format(http.TimeFormat))
+		}, true, "HEAD"},
+		{"head not allowed", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Last-Modified", installed.Add(time.Hour).Format(http.TimeFormat))
+			w.Write(bytes.Repeat([]byte("archive"), 1024))
+		}, true, "HEAD GET"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out.Reset()
+			var (
+				mu      sync.Mutex
+				methods []string
+			)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				methods = append(methods, r.Method)
+				mu.Unlock()
+				tc.handler(w, r)
+			}))
+			defer ts.Close()
+			dir, err := ioutil.TempDir("", "netup")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			c := newTestContext(t, dir, &DirectSource{Name: "vim", URL: ts.URL + "/vim.zip", Strip: 1})
+			zipName := filepath.Join(c.tmpDir, "vim.zip")
+			writeTestZip(t, zipName, map[string]string{"vim.exe": "vim"})
+			if err := extract(c, zipName, &anchorInfo{time: installed}); err != nil {
+				t.Fatalf("extract failed: %s", err)
+			}
+			os.Remove(zipName)
+
+			update, err := check(c)
+			if err != nil {
+				t.Fatalf("check failed: %s", err)
+			}
+			if update != tc.update {
+				t.Errorf("check should return %v", tc.update)
+			}
+			want := `"event":"not_modified"`
+			if tc.update {
+				want = `"event":"update_available"`
+			}
+			if s := out.String(); !strings.Contains(s, want) {
+				t.Errorf("%s should be emitted: %s", want, s)
+			}
+			if s := strings.Join(methods, " "); s != tc.methods {
+				t.Errorf("unexpected requests: %s", s)
+			}
+
+			// nothing is downloaded nor changed.
+			var files []string
+			filepath.Walk(c.dataDir, func(path string, fi os.FileInfo, err error) error {
+				if err == nil && !fi.IsDir() {
+					files = append(files, filepath.Base(path))
+				}
+				return nil
+			})
+			for _, f := range files {
+				if strings.HasSuffix(f, ".zip") || strings.HasSuffix(f, partialSuffix) {
+					t.Errorf("archive should not be downloaded: %v", files)
+				}
+			}
+			checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "vim")
+			if a, _ := loadAnchor(c.anchorPath()); !a.time.Equal(installed) {
+				t.Errorf("anchor should not be changed: %+v", a)
+			}
+		})
+	}
+}
+
+func TestCheckKeepsPendingTxn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, _ := setupPlanTest(t, dir)
+	journal := filepath.Join(c.txnDir(), txnJournalName)
+	writeTestFile(t, journal, "")
+
+	pack := SourcePack{arch.X86: c.source}
+	ok, err := Check(stdctx.Background(), c.targetDir, c.dataDir, pack, Arch{Name: "x86"})
+	if err != nil || !ok {
+		t.Fatalf("Check should find an update: %t %v", ok, err)
+	}
+	if _, err := os.Stat(journal); err != nil {
+		t.Errorf("pending transaction should be kept by check: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package netup
 
 import (
+	stdctx "context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,10 +10,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/koron/go-arch"
-	"github.com/koron/go-github"
 )
 
 var (
@@ -21,20 +22,49 @@ var (
 	errGithubNoRelease       = errors.New("absence of github release")
 	errGithubNoAssets        = errors.New("no matched assets in github release")
 	errGithubIncompleteAsset = errors.New("incomplete github asset")
+	errGithubNoChecksum      = errors.New("no matched checksum assets in github release")
+	errGithubNoPinned        = errors.New("no github releases match version")
 )
 
 type progressFunc func(curr, max int64)
 
+// release describes an available release of source.
+type release struct {
+	name       string
+	updated    time.Time
+	prerelease bool
+}
+
+func (r *release) String() string {
+	s := r.name
+	if r.prerelease {
+		s += " (pre-release)"
+	}
+	if r.updated.IsZero() {
+		return s
+	}
+	return fmt.Sprintf("%s (updated at %s)", s, r.updated.Format(time.RFC3339))
+}
+
 // Source describes source of update.
 type Source interface {
-	// download downloads source file to outdir, return its path name.
-	// if pivot is not zero, this checks changes of source from pivot.
-	download(outdir string, pivot time.Time, f progressFunc) (path string, err error)
+	// download downloads source file to outdir, return its path name and
+	// the release.  if anchor is not empty, this checks changes of source
+	// from anchor.
+	download(ctx stdctx.Context, outdir string, anchor *anchorInfo, f progressFunc) (path string, r *release, err error)
+
+	// check checks a release newer than anchor is available without
+	// downloading it.  It returns errSourceNotModified when no updates.
+	check(ctx stdctx.Context, anchor *anchorInfo) (*release, error)
 
 	stripCount() int
 
 	name() string
 
+	// id returns an identifier of distribution, which is used to detect
+	// changes of source.  It doesn't contain version pinning.
+	id() string
+
 	// String returns a string to represent source.
 	String() string
 }
@@ -44,12 +74,56 @@ type DirectSource struct {
 	Name  string
 	URL   string
 	Strip int
+
+	// SHA256 is expected SHA-256 checksum of the archive (optional).
+	SHA256 string
+
+	// SHA256URL is URL of checksum file for the archive (optional).
+	SHA256URL string
 }
 
 var _ Source = (*DirectSource)(nil)
 
-func (ds *DirectSource) download(d string, p time.Time, f progressFunc) (string, error) {
-	return download(ds.URL, d, p, f)
+func (ds *DirectSource) download(ctx stdctx.Context, d string, an *anchorInfo, f progressFunc) (string, *release, error) {
+	sum, err := ds.checksum(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	path, err := download(ctx, ds.URL, d, an.time, f)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := verifySHA256(path, sum); err != nil {
+		return "", nil, err
+	}
+	return path, &release{name: filepath.Base(path)}, nil
+}
+
+func (ds *DirectSource) check(ctx stdctx.Context, an *anchorInfo) (*release, error) {
+	name, err := downloadFilepath(ds.URL, "")
+	if err != nil {
+		return nil, err
+	}
+	p := an.time
+	lm, err := lastModified(ctx, ds.URL, p)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsZero() && !lm.IsZero() && !lm.After(p) {
+		return nil, errSourceNotModified
+	}
+	return &release{name: name, updated: lm}, nil
+}
+
+func (ds *DirectSource) checksum(ctx stdctx.Context) (string, error) {
+	if ds.SHA256 != "" || ds.SHA256URL == "" {
+		return ds.SHA256, nil
+	}
+	name, err := downloadFilepath(ds.URL, "")
+	if err != nil {
+		return "", err
+	}
+	return fetchChecksum(ctx, ds.SHA256URL, nil, name)
 }
 
 func (ds *DirectSource) stripCount() int {
@@ -60,6 +134,10 @@ func (ds *DirectSource) name() string {
 	return ds.Name
 }
 
+func (ds *DirectSource) id() string {
+	return "direct:" + ds.URL
+}
+
 func (ds *DirectSource) String() string {
 	return fmt.Sprintf("direct: URL=%s", ds.URL)
 }
@@ -71,20 +149,85 @@ type GithubSource struct {
 	Project string
 	NamePat *regexp.Regexp
 	Strip   int
+
+	// SHA256 is expected SHA-256 checksum of the archive (optional).
+	SHA256 string
+
+	// SHA256Pat is pattern of checksum asset's name in same release, like
+	// "*.sha256" or "SHA256SUMS" (optional).
+	SHA256Pat *regexp.Regexp
+
+	// Version pins release by exact tag like "v8.1.1234", or range of
+	// versions like ">=8.1 <8.2" or "8.1.*" (optional).
+	Version string
+
+	// APIURL is base URL of GitHub API, like "https://ghe.example.com/api/v3"
+	// for GitHub Enterprise (optional).  Default is api.github.com.
+	APIURL string
+
+	// Token is a token for the API (optional).  GithubToken is used only
+	// for api.github.com when it is empty.
+	Token string
+
+	// PreRelease follows pre-releases too.  The newest release which has
+	// the asset is chosen.
+	PreRelease bool
 }
 
 var _ Source = (*GithubSource)(nil)
 
-func (gs *GithubSource) download(d string, p time.Time, f progressFunc) (string, error) {
-	a, err := gs.fetchAsset(p)
+func (gs *GithubSource) download(ctx stdctx.Context, d string, an *anchorInfo, f progressFunc) (string, *release, error) {
+	r, a, err := gs.fetchAsset(ctx, an)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	if !p.IsZero() && p.After(a.UpdatedAt) {
-		return "", errSourceNotModified
+	if r.PreRelease {
+		msgPrintf("found newer pre-release %s on GitHub\n", r.TagName)
+	} else {
+		msgPrintln("found newer release on GitHub")
+	}
+	sum, err := gs.checksum(ctx, r, a)
+	if err != nil {
+		return "", nil, err
 	}
-	msgPrintln("found newer release on GitHub")
-	return download(a.DownloadURL, d, p, f)
+	p := an.time
+	if gs.Version != "" {
+		// pinned release may be older than anchor.
+		p = time.Time{}
+	}
+	u, h := gs.client().assetRequest(a)
+	path := filepath.Join(d, filepath.Base(a.Name))
+	if err := downloadAsFile(ctx, u, path, h, p, f); err != nil {
+		return "", nil, err
+	}
+	if err := verifySHA256(path, sum); err != nil {
+		return "", nil, err
+	}
+	return path, assetRelease(r, a), nil
+}
+
+func (gs *GithubSource) check(ctx stdctx.Context, an *anchorInfo) (*release, error) {
+	r, a, err := gs.fetchAsset(ctx, an)
+	if err != nil {
+		return nil, err
+	}
+	return assetRelease(r, a), nil
+}
+
+func assetRelease(r *githubRelease, a *githubAsset) *release {
+	return &release{name: a.Name, updated: a.UpdatedAt, prerelease: r.PreRelease}
+}
+
+func (gs *GithubSource) checksum(ctx stdctx.Context, r *githubRelease, a *githubAsset) (string, error) {
+	if gs.SHA256 != "" || gs.SHA256Pat == nil {
+		return gs.SHA256, nil
+	}
+	sa := findAsset(r, gs.SHA256Pat)
+	if sa == nil {
+		return "", errGithubNoChecksum
+	}
+	u, h := gs.client().assetRequest(sa)
+	return fetchChecksum(ctx, u, h, a.Name)
 }
 
 func (gs *GithubSource) stripCount() int {
@@ -95,36 +238,223 @@ func (gs *GithubSource) name() string {
 	return gs.Name
 }
 
-func (gs *GithubSource) fetchAsset(pivot time.Time) (*github.Asset, error) {
-	r, err := github.LatestIfModifiedSince(gs.User, gs.Project, pivot)
+func (gs *GithubSource) id() string {
+	if gs.APIURL != "" {
+		return fmt.Sprintf("github:%s/%s/%s:%s",
+			strings.TrimRight(gs.APIURL, "/"), gs.User, gs.Project, gs.NamePat.String())
+	}
+	return fmt.Sprintf("github:%s/%s:%s", gs.User, gs.Project, gs.NamePat.String())
+}
+
+// client returns a client of GitHub API for the source.
+func (gs *GithubSource) client() *githubClient {
+	if gs.APIURL == "" && gs.Token == "" {
+		return githubDefault
+	}
+	return &githubClient{
+		baseURL:  gs.APIURL,
+		token:    gs.Token,
+		cacheDir: githubDefault.cacheDir,
+	}
+}
+
+// fetchAsset determines a release and an asset to download.  It returns
+// errSourceNotModified when the asset isn't newer than anchor.
+func (gs *GithubSource) fetchAsset(ctx stdctx.Context, an *anchorInfo) (*githubRelease, *githubAsset, error) {
+	if gs.Version != "" {
+		return gs.fetchPinnedAsset(ctx, an)
+	}
+	if gs.PreRelease {
+		return gs.fetchNewestAsset(ctx, an)
+	}
+	pivot := an.time
+	if an.prerelease {
+		// installed pre-release may be newer than the latest release.
+		pivot = time.Time{}
+	}
+	r, err := gs.client().latestRelease(ctx, gs.User, gs.Project, pivot)
 	if err != nil {
-		if err == github.ErrNotModified {
-			err = errSourceNotModified
-		}
-		return nil, err
+		return nil, nil, err
 	}
 	if r.Draft || r.PreRelease {
-		return nil, errGithubNoRelease
-	}
-	var t *github.Asset
-	for _, a := range r.Assets {
-		if gs.NamePat.MatchString(a.Name) {
-			t = &a
-			break
-		}
+		return nil, nil, errGithubNoRelease
 	}
+	t := findAsset(r, gs.NamePat)
 	if t == nil {
-		return nil, errGithubNoAssets
+		return nil, nil, errGithubNoAssets
 	}
 	if t.State != "uploaded" {
-		return nil, errGithubIncompleteAsset
+		return nil, nil, errGithubIncompleteAsset
+	}
+	if an.prerelease {
+		if an.archive == t.Name {
+			return nil, nil, errSourceNotModified
+		}
+		logInfo("switch from pre-release %s to release %s", an.archive, r.TagName)
+		return r, t, nil
+	}
+	if !an.time.IsZero() && an.time.After(t.UpdatedAt) {
+		return nil, nil, errSourceNotModified
 	}
-	return t, nil
+	return r, t, nil
+}
+
+// fetchNewestAsset determines the newest release including pre-releases,
+// which has the asset.
+func (gs *GithubSource) fetchNewestAsset(ctx stdctx.Context, an *anchorInfo) (*githubRelease, *githubAsset, error) {
+	rr, err := gs.client().listReleases(ctx, gs.User, gs.Project)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		newest *githubRelease
+		na     *githubAsset
+	)
+	for i := range rr {
+		r := &rr[i]
+		if r.Draft {
+			continue
+		}
+		a := findAsset(r, gs.NamePat)
+		if a == nil || a.State != "uploaded" {
+			continue
+		}
+		if newest == nil || r.PublishedAt.After(newest.PublishedAt) {
+			newest, na = r, a
+		}
+	}
+	if newest == nil {
+		return nil, nil, errGithubNoAssets
+	}
+	logInfo("newest release is %s (prerelease=%t)", newest.TagName, newest.PreRelease)
+	if !an.time.IsZero() && an.time.After(na.UpdatedAt) {
+		return nil, nil, errSourceNotModified
+	}
+	return newest, na, nil
+}
+
+// fetchPinnedAsset determines the newest release which matches with Version.
+// It reports newer releases which don't match.  Pages of releases are read
+// until a match is found or releases are older than the range, and an exact
+// tag which isn't in recent releases is got by the tag.
+func (gs *GithubSource) fetchPinnedAsset(ctx stdctx.Context, an *anchorInfo) (*githubRelease, *githubAsset, error) {
+	pin, err := parseVersionPin(gs.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		gc             = gs.client()
+		latest, pinned *githubRelease
+		pa             *githubAsset
+	)
+	err = gc.eachReleases(ctx, gs.User, gs.Project, func(rr []githubRelease) bool {
+		for i := range rr {
+			r := &rr[i]
+			a := gs.releaseAsset(r)
+			if a == nil {
+				continue
+			}
+			v := parseVersion(r.TagName)
+			if latest == nil || compareVersion(v, parseVersion(latest.TagName)) > 0 {
+				latest = r
+			}
+			if !pin.match(r.TagName) {
+				continue
+			}
+			if pinned == nil || compareVersion(v, parseVersion(pinned.TagName)) > 0 {
+				pinned, pa = r, a
+			}
+		}
+		if pinned != nil || pin.exact != "" || len(rr) == 0 {
+			return false
+		}
+		return !pin.below(parseVersion(rr[len(rr)-1].TagName))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if pinned == nil && pin.exact != "" {
+		pinned, pa, err = gs.fetchTaggedAsset(ctx, gc, pin.exact)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if pinned == nil {
+		return nil, nil, errGithubNoPinned
+	}
+	logInfo("resolved version %s to release %s", pin, pinned.TagName)
+	if latest != nil && latest != pinned && compareVersion(parseVersion(latest.TagName), parseVersion(pinned.TagName)) > 0 {
+		logInfo("newer release %s is out of version %s", latest.TagName, pin)
+		msgPrintf("newer release %s is available, but out of version %s\n",
+			latest.TagName, pin)
+	}
+	// compare with archive name, because pinned release may be older.
+	if !an.time.IsZero() && an.time.After(pa.UpdatedAt) &&
+		(an.archive == "" || an.archive == pa.Name) {
+		return nil, nil, errSourceNotModified
+	}
+	return pinned, pa, nil
+}
+
+// fetchTaggedAsset gets a release by an exact tag, with or without "v"
+// prefix.  It returns nil when the release isn't found or has no asset.
+func (gs *GithubSource) fetchTaggedAsset(ctx stdctx.Context, gc *githubClient, tag string) (*githubRelease, *githubAsset, error) {
+	alt := "v" + tag
+	if strings.HasPrefix(tag, "v") {
+		alt = tag[1:]
+	}
+	for _, t := range []string{tag, alt} {
+		r, err := gc.releaseByTag(ctx, gs.User, gs.Project, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		if r == nil {
+			continue
+		}
+		if a := gs.releaseAsset(r); a != nil {
+			return r, a, nil
+		}
+		return nil, nil, nil
+	}
+	return nil, nil, nil
+}
+
+// releaseAsset returns the asset of a release, or nil when the release can't
+// be used: a draft, a pre-release without PreRelease, or without uploaded
+// asset.
+func (gs *GithubSource) releaseAsset(r *githubRelease) *githubAsset {
+	if r.Draft || (r.PreRelease && !gs.PreRelease) {
+		return nil
+	}
+	a := findAsset(r, gs.NamePat)
+	if a == nil || a.State != "uploaded" {
+		return nil
+	}
+	return a
+}
+
+func findAsset(r *githubRelease, pat *regexp.Regexp) *githubAsset {
+	for i := range r.Assets {
+		if pat.MatchString(r.Assets[i].Name) {
+			return &r.Assets[i]
+		}
+	}
+	return nil
 }
 
 func (gs *GithubSource) String() string {
-	return fmt.Sprintf("GitHub: %s/%s pattern=%s",
+	s := fmt.Sprintf("GitHub: %s/%s pattern=%s",
 		gs.User, gs.Project, gs.NamePat.String())
+	if gs.Version != "" {
+		s += " version=" + gs.Version
+	}
+	if gs.PreRelease {
+		s += " prerelease"
+	}
+	if gs.APIURL != "" {
+		s += " api=" + gs.APIURL
+	}
+	return s
 }
 
 func downloadFilepath(inURL, outdir string) (string, error) {
@@ -137,17 +467,103 @@ func downloadFilepath(inURL, outdir string) (string, error) {
 
 var downloadTimeout = 5 * time.Minute
 
-func downloadAsFile(inURL, outPath string, pivot time.Time, pf progressFunc) error {
+// lastModified checks last modified time of URL by HEAD request, or
+// conditional GET when HEAD isn't allowed.  It returns errSourceNotModified
+// when the server says so.  Zero time is returned when unknown.
+func lastModified(ctx stdctx.Context, inURL string, pivot time.Time) (time.Time, error) {
+	var t time.Time
+	err := withRetry(ctx, "check URL "+inURL, func() error {
+		var err error
+		t, err = tryLastModified(ctx, inURL, pivot)
+		return err
+	})
+	return t, err
+}
+
+func tryLastModified(ctx stdctx.Context, inURL string, pivot time.Time) (time.Time, error) {
+	var resp *http.Response
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, inURL, nil)
+		if err != nil {
+			return time.Time{}, err
+		}
+		req = req.WithContext(ctx)
+		if !pivot.IsZero() {
+			req.Header.Set("If-Modified-Since", pivot.UTC().Format(http.TimeFormat))
+		}
+		logInfo("check URL %s by %s", inURL, method)
+		client := http.Client{Timeout: downloadTimeout}
+		resp, err = client.Do(req)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// don't read body of GET.
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			break
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		t, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		if err != nil {
+			return time.Time{}, nil
+		}
+		return t, nil
+	case http.StatusNotModified:
+		return time.Time{}, errSourceNotModified
+	default:
+		return time.Time{}, newHTTPError(resp, fmt.Sprintf("unexpected response: %s", resp.Status))
+	}
+}
+
+var errPartialRejected = errors.New("partial download rejected")
+
+// downloadAsFile downloads URL as a file with extra request headers h
+// (optional).  Partial file of previous download is resumed when possible, so
+// a retry after transient errors continues from where the last attempt
+// stopped.
+func downloadAsFile(ctx stdctx.Context, inURL, outPath string, h http.Header, pivot time.Time, pf progressFunc) error {
+	err := withRetry(ctx, "download "+inURL, func() error {
+		err := tryDownload(ctx, inURL, outPath, h, pivot, pf)
+		if err == errPartialRejected {
+			logInfo("partial file rejected, restart download")
+			removePartial(outPath)
+			err = tryDownload(ctx, inURL, outPath, h, pivot, pf)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	cleanPartials(filepath.Dir(outPath))
+	return nil
+}
+
+func tryDownload(ctx stdctx.Context, inURL, outPath string, h http.Header, pivot time.Time, pf progressFunc) error {
 	req, err := http.NewRequest("GET", inURL, nil)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
+	for k, v := range h {
+		req.Header[k] = v
+	}
 	if !pivot.IsZero() {
 		t := pivot.UTC().Format(http.TimeFormat)
 		req.Header.Set("If-Modified-Since", t)
 	}
+	pi, offset := resumePoint(outPath)
+	if pi != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", pi.validator())
+	}
 	logInfo("download URL %s as file %s", inURL, outPath)
-	msgPrintf("download %s\n", inURL)
+	if OutputJSON {
+		msgEmit("download", msgEvent{"url": inURL, "offset": offset})
+	} else {
+		msgPrintf("download %s\n", inURL)
+	}
 	client := http.Client{Timeout: downloadTimeout}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -157,37 +573,84 @@ func downloadAsFile(inURL, outPath string, pivot time.Time, pf progressFunc) err
 
 	switch resp.StatusCode {
 	case http.StatusOK:
-		return saveBody(outPath, resp, pf)
+		if pi != nil {
+			logInfo("server ignored range, download whole file")
+		}
+		return saveBody(outPath, resp, newPartialInfo(resp), 0, pf)
+	case http.StatusPartialContent:
+		if pi == nil {
+			return fmt.Errorf("unexpected response: %s", resp.Status)
+		}
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			logInfo("failed to parse content range: %s", err)
+			return errPartialRejected
+		}
+		if start != offset || (pi.Length >= 0 && total >= 0 && total != pi.Length) {
+			return errPartialRejected
+		}
+		if pi.Length < 0 {
+			pi.Length = total
+		}
+		logInfo("resume download from %d bytes", offset)
+		return saveBody(outPath, resp, pi, offset, pf)
+	case http.StatusRequestedRangeNotSatisfiable:
+		if pi == nil {
+			return fmt.Errorf("unexpected response: %s", resp.Status)
+		}
+		return errPartialRejected
 	case http.StatusNotModified:
 		return errSourceNotModified
 	default:
-		return fmt.Errorf("unexpected response: %s", resp.Status)
+		return newHTTPError(resp, fmt.Sprintf("unexpected response: %s", resp.Status))
 	}
 }
 
 // download downloads URL and saves as a file to outdir, return its path name.
 // if pivot is not zero, this checks changes of source after pivot.
-func download(inURL, outdir string, pivot time.Time, f progressFunc) (string, error) {
+func download(ctx stdctx.Context, inURL, outdir string, pivot time.Time, f progressFunc) (string, error) {
 	path, err := downloadFilepath(inURL, outdir)
 	if err != nil {
 		return "", err
 	}
-	if err := downloadAsFile(inURL, path, pivot, f); err != nil {
+	if err := downloadAsFile(ctx, inURL, path, nil, pivot, f); err != nil {
 		return "", err
 	}
 	return path, nil
 }
 
-func saveBody(outPath string, resp *http.Response, pf progressFunc) error {
-	f, err := os.Create(outPath)
+// saveBody writes response body to partial file of outPath from offset, then
+// renames it to outPath when completed.
+func saveBody(outPath string, resp *http.Response, pi *partialInfo, offset int64, pf progressFunc) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flag = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(partialPath(outPath), flag, 0666)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	w := &progressWriter{w: f, f: pf, m: resp.ContentLength}
-	if _, err := io.Copy(w, resp.Body); err != nil {
+	if err := pi.save(outPath); err != nil {
+		logSavePartialFailed(err)
+	}
+	w := &progressWriter{w: f, f: pf, n: offset, m: pi.Length}
+	_, err = io.Copy(w, resp.Body)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	if pi.Length >= 0 && w.n != pi.Length {
+		if w.n > pi.Length {
+			removePartial(outPath)
+		}
+		return fmt.Errorf("content length mismatch: expected %d bytes but got %d", pi.Length, w.n)
+	}
+	if err := os.Rename(partialPath(outPath), outPath); err != nil {
 		return err
 	}
+	os.Remove(partialInfoPath(outPath))
 	return nil
 }
 
@@ -208,3 +671,11 @@ func (w *progressWriter) Write(p []byte) (int, error) {
 
 // SourcePack is the map arch.CPU to source.
 type SourcePack map[arch.CPU]Source
+
+// Name returns name of package which sources provide.
+func (sp SourcePack) Name() string {
+	for _, src := range sp {
+		return src.name()
+	}
+	return ""
+}
@@ -0,0 +1,308 @@
+package netup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	txnJournalName = "journal.txt"
+	txnStageName   = "stage"
+	txnBackupName  = "backup"
+)
+
+const (
+	txnOpRename = "rename"
+	txnOpMkdir  = "mkdir"
+	txnOpCommit = "commit"
+)
+
+// txnEntry is a record of an operation in transaction.
+type txnEntry struct {
+	op   string
+	from string
+	to   string
+}
+
+// txn is a transaction to update files in target directory.  All changes are
+// done by renames and recorded to journal before applied, so they can be
+// undone when failed or interrupted.
+type txn struct {
+	dir       string
+	stageDir  string
+	backupDir string
+	journal   *os.File
+	entries   []txnEntry
+	nbackup   int
+
+	// events are "file" events, which are emitted after commit.
+	events []msgEvent
+}
+
+// beginTxn starts a new transaction which uses dir as work space.
+func beginTxn(dir string) (*txn, error) {
+	if err := recoverTxn(dir); err != nil {
+		return nil, err
+	}
+	t := &txn{
+		dir:       dir,
+		stageDir:  filepath.Join(dir, txnStageName),
+		backupDir: filepath.Join(dir, txnBackupName),
+	}
+	for _, d := range []string{t.stageDir, t.backupDir} {
+		if err := os.MkdirAll(d, 0777); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, txnJournalName))
+	if err != nil {
+		return nil, err
+	}
+	t.journal = f
+	return t, nil
+}
+
+// stagePath returns a path to stage a file with relative name.
+func (t *txn) stagePath(name string) string {
+	return filepath.Join(t.stageDir, filepath.FromSlash(name))
+}
+
+// tempPath returns a path to stage a file which is not in target directory.
+func (t *txn) tempPath(name string) string {
+	return filepath.Join(t.dir, name)
+}
+
+func (t *txn) record(e txnEntry) error {
+	_, err := fmt.Fprintf(t.journal, "%s\t%s\t%s\n",
+		e.op, strconv.Quote(e.from), strconv.Quote(e.to))
+	if err != nil {
+		return err
+	}
+	if err := t.journal.Sync(); err != nil {
+		return err
+	}
+	t.entries = append(t.entries, e)
+	return nil
+}
+
+// mkdirAll creates a directory and its parents, records created ones.
+func (t *txn) mkdirAll(dir string) error {
+	fi, err := os.Stat(dir)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("not a directory: %s", dir)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if p := filepath.Dir(dir); p != dir {
+		if err := t.mkdirAll(p); err != nil {
+			return err
+		}
+	}
+	if err := t.record(txnEntry{op: txnOpMkdir, from: dir}); err != nil {
+		return err
+	}
+	return os.Mkdir(dir, 0777)
+}
+
+// rename renames a file with recording.  It returns an error which satisfies
+// os.IsNotExist when from doesn't exist.
+func (t *txn) rename(from, to string) error {
+	if _, err := os.Lstat(from); err != nil {
+		return err
+	}
+	if err := t.mkdirAll(filepath.Dir(to)); err != nil {
+		return err
+	}
+	if err := t.record(txnEntry{op: txnOpRename, from: from, to: to}); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// remove moves a file to backup.  It is succeeded when the file doesn't
+// exist.
+func (t *txn) remove(name string) error {
+	t.nbackup++
+	b := filepath.Join(t.backupDir, strconv.Itoa(t.nbackup))
+	if err := t.rename(name, b); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// replace replaces target file by staged file.
+func (t *txn) replace(staged, target string) error {
+	if err := t.remove(target); err != nil {
+		return err
+	}
+	return t.rename(staged, target)
+}
+
+// rotate rotates generations of a file, see rotateName.
+func (t *txn) rotate(name string, max int) error {
+	last := rotateName(name, max)
+	if err := t.remove(last); err != nil {
+		return err
+	}
+	for i := max - 1; i >= 0; i-- {
+		curr := rotateName(name, i)
+		err := t.rename(curr, last)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		last = curr
+	}
+	return nil
+}
+
+// writeFile writes a file which is not in target directory, like recipe or
+// anchor, as a part of transaction.
+func (t *txn) writeFile(target string, write func(name string) error) error {
+	tmp := t.tempPath(filepath.Base(target))
+	if err := write(tmp); err != nil {
+		return err
+	}
+	return t.replace(tmp, target)
+}
+
+// msgFile reports an action for a file, after the transaction is committed.
+func (t *txn) msgFile(action planAction, name string) {
+	t.events = append(t.events, fileEvent(action, name))
+}
+
+// commit completes the transaction.  Undo data is moved to keep, or
+// discarded when keep is empty.
+func (t *txn) commit(keep string) error {
+	if err := t.record(txnEntry{op: txnOpCommit}); err != nil {
+		return err
+	}
+	t.journal.Close()
+	for _, ev := range t.events {
+		msgEmit("file", ev)
+	}
+	if keep != "" {
+		if err := os.Rename(t.dir, keep); err == nil {
+			return nil
+		}
+		logWarn("failed to keep transaction for rollback: %s", keep)
+	}
+	if err := os.RemoveAll(t.dir); err != nil {
+		logCleanTxnFailed(err)
+	}
+	return nil
+}
+
+// rollback undoes all changes in the transaction.  Actions for files are
+// reported as one "rolled_back" event.
+func (t *txn) rollback() error {
+	t.journal.Close()
+	logInfo("rollback %d operations", len(t.entries))
+	if err := undoEntries(t.entries); err != nil {
+		return err
+	}
+	msgEmit("rolled_back", msgEvent{"files": len(t.events)})
+	if err := os.RemoveAll(t.dir); err != nil {
+		logCleanTxnFailed(err)
+	}
+	return nil
+}
+
+// relocateEntries rewrites paths in entries, which was under from, to be under
+// to.  It is used for transactions moved to history.
+func relocateEntries(entries []txnEntry, from, to string) {
+	fix := func(p string) string {
+		r, err := filepath.Rel(from, p)
+		if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			return p
+		}
+		return filepath.Join(to, r)
+	}
+	for i := range entries {
+		entries[i].from = fix(entries[i].from)
+		entries[i].to = fix(entries[i].to)
+	}
+}
+
+func undoEntries(entries []txnEntry) error {
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		switch e.op {
+		case txnOpRename:
+			err := os.Rename(e.to, e.from)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to undo rename %s: %s", e.from, err)
+			}
+		case txnOpMkdir:
+			os.Remove(e.from)
+		}
+	}
+	return nil
+}
+
+func loadJournal(name string) ([]txnEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []txnEntry
+	r := bufio.NewReader(f)
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				// ignore incomplete last line.
+				break
+			}
+			return nil, err
+		}
+		s := strings.Split(strings.TrimSuffix(l, "\n"), "\t")
+		if len(s) != 3 {
+			return nil, fmt.Errorf("broken journal: %q", l)
+		}
+		e := txnEntry{op: s[0]}
+		if e.from, err = strconv.Unquote(s[1]); err != nil {
+			return nil, err
+		}
+		if e.to, err = strconv.Unquote(s[2]); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// pendingTxn checks an interrupted transaction is left in dir.
+func pendingTxn(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, txnJournalName))
+	return err == nil
+}
+
+// recoverTxn finishes or undoes an interrupted transaction in dir.
+func recoverTxn(dir string) error {
+	entries, err := loadJournal(filepath.Join(dir, txnJournalName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.RemoveAll(dir)
+		}
+		return err
+	}
+	if n := len(entries); n > 0 && entries[n-1].op == txnOpCommit {
+		logInfo("found committed transaction, finish it")
+	} else {
+		logWarn("found interrupted transaction, undo %d operations", len(entries))
+		if err := undoEntries(entries); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,144 @@
+package netup
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name, s string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(s), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTestFile(t *testing.T, name, s string) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Errorf("failed to read %s: %s", name, err)
+		return
+	}
+	if string(b) != s {
+		t.Errorf("unexpected content of %s: %q", name, b)
+	}
+}
+
+func testTxn(t *testing.T, dir string) *txn {
+	tx, err := beginTxn(filepath.Join(dir, "txn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "sub", "b.txt")
+	writeTestFile(t, a, "old a")
+	writeTestFile(t, tx.stagePath("a.txt"), "new a")
+	writeTestFile(t, tx.stagePath("sub/b.txt"), "new b")
+	if err := tx.replace(tx.stagePath("a.txt"), a); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.replace(tx.stagePath("sub/b.txt"), b); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, a, "new a")
+	checkTestFile(t, b, "new b")
+	return tx
+}
+
+func TestTxnRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tx := testTxn(t, dir)
+	if err := tx.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(dir, "a.txt"), "old a")
+	if _, err := os.Stat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Errorf("created dir should be removed: %v", err)
+	}
+	if _, err := os.Stat(tx.dir); !os.IsNotExist(err) {
+		t.Errorf("transaction dir should be removed: %v", err)
+	}
+}
+
+func TestTxnRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// simulate interruption: journal is left without commit.
+	tx := testTxn(t, dir)
+	tx.journal.Close()
+	if err := recoverTxn(tx.dir); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(dir, "a.txt"), "old a")
+	if _, err := os.Stat(filepath.Join(dir, "sub", "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("new file should be removed: %v", err)
+	}
+}
+
+func TestTxnFileEvents(t *testing.T) {
+	defer func(b bool, w io.Writer) {
+		OutputJSON, msgOut = b, w
+	}(OutputJSON, msgOut)
+	var out bytes.Buffer
+	OutputJSON, msgOut = true, &out
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target", "a.txt")
+
+	// events are dropped by rollback.
+	tx, err := beginTxn(filepath.Join(dir, "txn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, tx.stagePath("a.txt"), "a")
+	if err := tx.replace(tx.stagePath("a.txt"), target); err != nil {
+		t.Fatal(err)
+	}
+	tx.msgFile(actionAdd, target)
+	if out.Len() != 0 {
+		t.Errorf("events should not be emitted before commit: %s", out.String())
+	}
+	if err := tx.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); !strings.Contains(s, `"event":"rolled_back"`) ||
+		!strings.Contains(s, `"files":1`) || strings.Contains(s, `"event":"file"`) {
+		t.Errorf("only rolled_back should be emitted: %s", s)
+	}
+
+	// events are emitted by commit.
+	out.Reset()
+	tx, err = beginTxn(filepath.Join(dir, "txn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, tx.stagePath("a.txt"), "a")
+	if err := tx.replace(tx.stagePath("a.txt"), target); err != nil {
+		t.Fatal(err)
+	}
+	tx.msgFile(actionAdd, target)
+	if err := tx.commit(""); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); !strings.Contains(s, `"event":"file"`) || !strings.Contains(s, `"action":"add"`) {
+		t.Errorf("file event should be emitted after commit: %s", s)
+	}
+}
@@ -0,0 +1,147 @@
+package netup
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// leftFile is a file or a directory which was left by uninstall.
+type leftFile struct {
+	path   string
+	reason string
+}
+
+func msgLeft(lf leftFile) {
+	if OutputJSON {
+		msgEmit("left", msgEvent{"path": lf.path, "reason": lf.reason})
+		return
+	}
+	msgPrintf("left: %s (%s)\n", lf.path, lf.reason)
+}
+
+// uninstall removes files in recipe which are not modified, then removes
+// empty directories and state of the package.  Modified, evacuated and
+// rotated files are kept unless purge is true.
+func uninstall(c *context, purge bool) error {
+	files, err := loadFileInfo(c.recipePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errNotInstalled
+		}
+		return err
+	}
+	t, err := beginTxn(c.txnDir())
+	if err != nil {
+		return err
+	}
+	counts := make(map[planAction]int)
+	left, err := uninstallTxn(c, t, files, purge, counts)
+	if err != nil {
+		if err2 := t.rollback(); err2 != nil {
+			logRollbackFailed(err2)
+		}
+		return err
+	}
+	if err := t.commit(""); err != nil {
+		return err
+	}
+	left = append(left, pruneDirs(c.targetDir, files)...)
+	if err := os.RemoveAll(c.varDir); err != nil {
+		logWarn("failed to remove state of package: %s", err)
+	}
+	logInfo("uninstalled %s from %s", c.source.name(), c.targetDir)
+	msgSummary(c.source.name(), counts)
+	for _, lf := range left {
+		msgLeft(lf)
+	}
+	return nil
+}
+
+func uninstallTxn(c *context, t *txn, files fileInfoTable, purge bool, counts map[planAction]int) ([]leftFile, error) {
+	o := c.loadOwners()
+	n, err := cleanFiles(t, c.targetDir, files, nil, o)
+	if err != nil {
+		return nil, err
+	}
+	counts[actionRemove] = n
+
+	// files which were kept by cleanFiles, and evacuated or rotated ones.
+	var left []leftFile
+	remove := func(p, reason string) error {
+		if _, err := os.Lstat(p); err != nil {
+			return nil
+		}
+		if _, ok := o.owner(p); ok {
+			return nil
+		}
+		if !purge {
+			left = append(left, leftFile{path: p, reason: reason})
+			return nil
+		}
+		if err := t.remove(p); err != nil {
+			return err
+		}
+		t.msgFile(actionRemove, p)
+		counts[actionRemove]++
+		return nil
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := filepath.Join(c.targetDir, name)
+		if err := remove(p, "modified"); err != nil {
+			return nil, err
+		}
+		if err := remove(evacuateName(p), "evacuated"); err != nil {
+			return nil, err
+		}
+		if !isRotateTarget(name) {
+			continue
+		}
+		for i := 1; i <= ExeRotateCount; i++ {
+			if err := remove(rotateName(p, i), "rotated"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return left, nil
+}
+
+// pruneDirs removes empty directories of files under target dir, deeper
+// first.  It returns directories which are not empty.
+func pruneDirs(dir string, files fileInfoTable) []leftFile {
+	dir = filepath.Clean(dir)
+	dirs := make(map[string]bool)
+	for name := range files {
+		for d := filepath.Dir(filepath.Join(dir, name)); d != dir; d = filepath.Dir(d) {
+			if !strings.HasPrefix(d, dir) || dirs[d] {
+				break
+			}
+			dirs[d] = true
+		}
+	}
+	list := make([]string, 0, len(dirs))
+	for d := range dirs {
+		list = append(list, d)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(list)))
+	var left []leftFile
+	for _, d := range list {
+		if _, err := os.Stat(d); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Remove(d); err != nil {
+			logInfo("keep directory %s: %s", d, err)
+			// report the deepest one only.
+			if len(left) == 0 || !strings.HasPrefix(left[len(left)-1].path, d+string(filepath.Separator)) {
+				left = append(left, leftFile{path: d, reason: "not empty"})
+			}
+		}
+	}
+	return left
+}
@@ -0,0 +1,46 @@
+package netup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUninstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestContext(t, dir, &DirectSource{Name: "vim", Strip: 1})
+	zipName := filepath.Join(c.tmpDir, "vim.zip")
+	writeTestZip(t, zipName, map[string]string{
+		"vim.exe":            "exe",
+		"runtime/a.vim":      "vim script",
+		"runtime/doc/a.txt":  "document",
+		"runtime/keep/b.txt": "document",
+	})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	writeTestFile(t, filepath.Join(c.targetDir, "runtime", "a.vim"), "modified")
+	writeTestFile(t, filepath.Join(c.targetDir, "vim.1.exe"), "rotated")
+	writeTestFile(t, filepath.Join(c.targetDir, "runtime", "keep", "mine.txt"), "user's file")
+
+	if err := uninstall(c, false); err != nil {
+		t.Fatalf("uninstall failed: %s", err)
+	}
+	for _, name := range []string{"vim.exe", "runtime/doc", "runtime/keep/b.txt"} {
+		if _, err := os.Stat(filepath.Join(c.targetDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed: %v", name, err)
+		}
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "a.vim"), "modified")
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.1.exe"), "rotated")
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "keep", "mine.txt"), "user's file")
+	if _, err := os.Stat(c.varDir); !os.IsNotExist(err) {
+		t.Errorf("state of package should be removed: %v", err)
+	}
+}
@@ -1,11 +1,10 @@
 package netup
 
 import (
+	stdctx "context"
 	"fmt"
 	"path/filepath"
 	"time"
-
-	"github.com/koron/go-github"
 )
 
 var (
@@ -31,27 +30,173 @@ var (
 
 	// ExeRotateCount is used for executable files rotation.
 	ExeRotateCount = 5
+
+	// HistoryCount is number of generations which be kept for rollback.
+	HistoryCount = 3
+
+	// ExtractWorkers is number of workers to compare and extract files in
+	// archive concurrently.
+	ExtractWorkers = 4
+
+	// RetryCount is max number of retries for transient errors of GitHub API
+	// and downloads.
+	RetryCount = 3
+
+	// RetryWait is initial wait before a retry, which doubles at each retry.
+	RetryWait = time.Second
+
+	// LockTimeout is timeout to wait for other process which is running on
+	// same work dir.  It fails immediately when zero.
+	LockTimeout = time.Minute
+
+	// ArchiveMaxEntries is max number of files in an archive.  Zero means no
+	// limit.
+	ArchiveMaxEntries = 100000
+
+	// ArchiveMaxSize is max total size of files in an archive when extracted.
+	// Zero means no limit.
+	ArchiveMaxSize int64 = 4 << 30
+
+	// ArchiveMaxRatio is max ratio of total size of files to size of an
+	// archive.  Zero means no limit.
+	ArchiveMaxRatio = 100
 )
 
 // Update updates or installs a package into target directory.
-func Update(targetDir, workDir string, srcPack SourcePack, arch Arch, restoreFlag bool) error {
+func Update(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch, restoreFlag bool) error {
+	c, err := setupWrite(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	// Run update.
+	proc := update
+	if restoreFlag {
+		proc = restore
+	}
+	if err := proc(c); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Rollback restores a previous installation of a package.  gen is number of
+// generations to go back, 1 means the installation before last update.
+func Rollback(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch, gen int) error {
+	c, err := setupWrite(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	return rollback(c, gen)
+}
+
+// Check checks an update of a package is available or not, without
+// downloading it.
+func Check(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch) (bool, error) {
+	c, err := setup(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+	warnPendingTxn(c)
+	return check(c)
+}
+
+// Verify checks files of an installation with its recipe.  It returns true
+// when no files are missing nor modified.
+func Verify(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch) (bool, error) {
+	c, err := setup(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+	warnPendingTxn(c)
+	return verify(c)
+}
+
+// Repair re-extracts only missing or modified files of an installation.
+func Repair(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch) error {
+	c, err := setupWrite(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	return repair(c)
+}
+
+// Uninstall removes files of a package which are not modified, and its state
+// in work dir.  Modified, evacuated and rotated files are removed too when
+// purge is true.
+func Uninstall(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch, purge bool) error {
+	c, err := setupWrite(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	return uninstall(c, purge)
+}
+
+// DryRun shows a plan to update a package without changing target directory.
+// The plan is saved to planFile if it isn't empty.
+func DryRun(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch, planFile string) error {
+	c, err := setup(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	warnPendingTxn(c)
+	return dryRun(c, planFile)
+}
+
+// ApplyPlan updates a package by a plan which saved by DryRun.  It fails when
+// the archive or the recipe was changed after the plan was made.
+func ApplyPlan(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch, planFile string) error {
+	c, err := setup(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	// recipe isn't migrated, because the plan has checksum of it and
+	// extraction rewrites it.
+	if err := recoverTxn(c.txnDir()); err != nil {
+		return err
+	}
+	return applyPlan(c, planFile)
+}
+
+// warnPendingTxn warns an interrupted transaction, which read only operations
+// don't recover.
+func warnPendingTxn(c *context) {
+	if pendingTxn(c.txnDir()) {
+		logWarn("found interrupted transaction, it isn't recovered by read only operation")
+		msgWarn("found interrupted transaction, it will be recovered by next update")
+	}
+}
+
+// setup determines source and prepares context and environment.  It takes
+// the lock of work dir unless LockWorkDir has taken it, so the context should
+// be closed after use.  It doesn't
+// change files of the package, for read only operations.
+func setup(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch) (*context, error) {
 	// deterine source.
 	cpu, err := arch.detectCPU(targetDir)
 	if err != nil {
-		return fmt.Errorf("can't detect CPU: %s", err)
+		return nil, fmt.Errorf("can't detect CPU: %s", err)
 	}
 	src, ok := srcPack[cpu]
 	if !ok {
-		return fmt.Errorf("unsupported arch: %+v", arch)
+		return nil, fmt.Errorf("unsupported arch: %+v", arch)
 	}
 
 	// setup environment.
 	downloadTimeout = DownloadTimeout
-	if GithubToken != "" {
-		github.DefaultClient.Token = GithubToken
-	}
+	githubDefault.token = GithubToken
 
-	ctx := &context{
+	c := &context{
+		ctx:       ctx,
 		targetDir: targetDir,
 		dataDir:   workDir,
 		logDir:    filepath.Join(workDir, "log"),
@@ -59,27 +204,49 @@ func Update(targetDir, workDir string, srcPack SourcePack, arch Arch, restoreFla
 		varDir:    filepath.Join(workDir, "var", src.name()),
 		source:    src,
 	}
-	if err := ctx.mkdirAll(); err != nil {
-		return err
+	if err := c.mkdirAll(); err != nil {
+		return nil, err
+	}
+	githubDefault.cacheDir = filepath.Join(c.varDir, "github")
+	if !lockedByRun(c.lockPath()) {
+		c.lock, err = acquireLock(ctx, c.lockPath(), LockTimeout)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	logSetup(ctx.logDir, LogRotateCount)
+	logSetup(c.logDir, LogRotateCount)
 	if GithubUser != "" {
 		logWarn("GithubUser (from config or env) is deprecated and ignored")
 	}
-	if GithubVerbose {
-		github.DefaultClient.Logger = logger
-	}
-	logInfo("context: target=%s source=%s", ctx.targetDir, ctx.source)
+	logInfo("context: target=%s source=%s", c.targetDir, c.source)
+	msgEmit("source", msgEvent{
+		"package": src.name(),
+		"source":  src.String(),
+		"target":  targetDir,
+	})
 
-	// Run update.
-	proc := update
-	if restoreFlag {
-		proc = restore
+	return c, nil
+}
+
+// setupWrite is setup for operations which change files of the package.  It
+// finishes or undoes an interrupted transaction, and migrates recipe of old
+// version.
+func setupWrite(ctx stdctx.Context, targetDir, workDir string, srcPack SourcePack, arch Arch) (*context, error) {
+	c, err := setup(ctx, targetDir, workDir, srcPack, arch)
+	if err != nil {
+		return nil, err
 	}
-	if err := proc(ctx); err != nil {
-		return err
+	if err := recoverTxn(c.txnDir()); err != nil {
+		c.close()
+		return nil, err
 	}
-
-	return nil
+	var release string
+	if a, err := loadAnchor(c.anchorPath()); err == nil {
+		release = a.archive
+	}
+	if err := migrateRecipe(c.recipePath(), c.targetDir, c.source, release); err != nil {
+		logWarn("failed to migrate recipe: %s", err)
+	}
+	return c, nil
 }
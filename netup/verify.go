@@ -0,0 +1,243 @@
+package netup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	errNotInstalled     = errors.New("not installed, recipe not found")
+	errArchiveNotCached = errors.New("archive of installed release is not cached, try -restore")
+)
+
+// verifyReport is a result to verify an installation.
+type verifyReport struct {
+	total     int
+	missing   []fileInfo
+	modified  []fileInfo
+	untracked []string
+}
+
+func (r *verifyReport) damaged() []fileInfo {
+	return append(append([]fileInfo{}, r.missing...), r.modified...)
+}
+
+// verifyFiles checks files in recipe with target dir.  Files which are not in
+// recipe nor owned by other packages are reported as untracked.
+func verifyFiles(c *context) (*verifyReport, error) {
+	files, err := loadFileInfo(c.recipePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errNotInstalled
+		}
+		return nil, err
+	}
+	list := make([]fileInfo, 0, len(files))
+	for _, fi := range files {
+		list = append(list, fi)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	results := make([]compareResult, len(list))
+	err = parallelEach(len(list), ExtractWorkers, func(i int) error {
+		r, err := list[i].compareWithFile(filepath.Join(c.targetDir, list[i].name))
+		if err != nil {
+			return err
+		}
+		results[i] = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report := &verifyReport{total: len(list)}
+	for i, r := range results {
+		switch r {
+		case fileNotExist:
+			report.missing = append(report.missing, list[i])
+		case fileNotMatch:
+			report.modified = append(report.modified, list[i])
+		}
+	}
+	report.untracked, err = untrackedFiles(c, files)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// untrackedFiles returns files in target dir, which are not in recipe nor
+// owned by other packages.  Work dir is excluded.
+func untrackedFiles(c *context, files fileInfoTable) ([]string, error) {
+	o := c.loadOwners()
+	workDir, err := filepath.Abs(c.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var untracked []string
+	err = filepath.Walk(c.targetDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if a, err := filepath.Abs(p); err == nil && a == workDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(c.targetDir, p)
+		if err != nil {
+			return err
+		}
+		if _, ok := files[filepath.ToSlash(rel)]; ok {
+			return nil
+		}
+		if _, ok := o.owner(p); ok {
+			return nil
+		}
+		untracked = append(untracked, filepath.ToSlash(rel))
+		return nil
+	})
+	return untracked, err
+}
+
+func msgVerify(status, name string) {
+	if OutputJSON {
+		msgEmit("verify", msgEvent{"status": status, "path": name})
+		return
+	}
+	msgPrintf("%-9s %s\n", status, name)
+}
+
+// verify checks an installation, returns true when it is not damaged.
+func verify(c *context) (bool, error) {
+	r, err := verifyFiles(c)
+	if err != nil {
+		return false, err
+	}
+	for _, fi := range r.missing {
+		msgVerify("missing", fi.name)
+	}
+	for _, fi := range r.modified {
+		msgVerify("modified", fi.name)
+	}
+	for _, name := range r.untracked {
+		msgVerify("untracked", name)
+	}
+	logInfo("verified %d files: %d missing, %d modified, %d untracked",
+		r.total, len(r.missing), len(r.modified), len(r.untracked))
+	if OutputJSON {
+		msgEmit("verify_summary", msgEvent{
+			"package":   c.source.name(),
+			"total":     r.total,
+			"missing":   len(r.missing),
+			"modified":  len(r.modified),
+			"untracked": len(r.untracked),
+		})
+	} else {
+		msgPrintf("%s: %d files verified, %d missing, %d modified, %d untracked\n",
+			c.source.name(), r.total, len(r.missing), len(r.modified), len(r.untracked))
+	}
+	return len(r.missing) == 0 && len(r.modified) == 0, nil
+}
+
+// repair re-extracts damaged files from the cached archive of the installed
+// release.  It fails when the archive isn't cached, because a download may
+// provide other release.
+func repair(c *context) error {
+	r, err := verifyFiles(c)
+	if err != nil {
+		return err
+	}
+	damaged := r.damaged()
+	if len(damaged) == 0 {
+		msgPrintln("no damaged files")
+		return nil
+	}
+	a, err := loadAnchor(c.anchorPath())
+	if err != nil {
+		return err
+	}
+	zipName := filepath.Join(c.archiveDir(), a.archive)
+	if _, err := os.Stat(zipName); a.archive == "" || err != nil {
+		return errArchiveNotCached
+	}
+	return repairFiles(c, zipName, damaged)
+}
+
+// repairFiles extracts damaged files from an archive as a transaction.  A
+// file which differs from recipe in the archive can't be repaired.
+func repairFiles(c *context, zipName string, damaged []fileInfo) error {
+	a, err := openArchive(zipName, c.tmpDir)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	t, err := beginTxn(c.txnDir())
+	if err != nil {
+		return err
+	}
+	counts, failed, err := repairTxn(c, t, a, damaged)
+	if err != nil {
+		if err2 := t.rollback(); err2 != nil {
+			logRollbackFailed(err2)
+		}
+		return err
+	}
+	if err := t.commit(""); err != nil {
+		return err
+	}
+	msgSummary(c.source.name(), counts)
+	if failed > 0 {
+		return fmt.Errorf("%d files couldn't be repaired, try -restore", failed)
+	}
+	return nil
+}
+
+func repairTxn(c *context, t *txn, a archive, damaged []fileInfo) (map[planAction]int, int, error) {
+	files, err := archiveFiles(a, c.source.stripCount(), c.targetDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := make(map[string]*archiveEntry)
+	for _, ae := range files {
+		entries[stripPath(ae.name, c.source.stripCount())] = ae
+	}
+	var (
+		staged []stagedFile
+		failed int
+	)
+	for _, fi := range damaged {
+		if err := c.ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		ae, ok := entries[fi.name]
+		if !ok || ae.crc32 != fi.hash || ae.size != fi.size {
+			logWarn("can't repair %s: not found in the archive", fi.name)
+			failed++
+			continue
+		}
+		stageName := t.stagePath(fi.name)
+		if _, err := stageFile(ae, stageName); err != nil {
+			return nil, 0, err
+		}
+		target := filepath.Join(c.targetDir, fi.name)
+		action := actionOverwrite
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			action = actionAdd
+		}
+		staged = append(staged, stagedFile{
+			staged: stageName,
+			target: target,
+			rotate: isRotateTarget(fi.name),
+			action: action,
+		})
+	}
+	counts := make(map[planAction]int)
+	if err := applyStagedFiles(c.ctx, t, staged, counts); err != nil {
+		return nil, 0, err
+	}
+	return counts, failed, nil
+}
@@ -0,0 +1,125 @@
+package netup
+
+import (
+	"archive/zip"
+	stdctx "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koron/go-arch"
+)
+
+// writeTestZip creates a zip file which has files under "vim/" dir.
+func writeTestZip(t *testing.T, name string, files map[string]string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for k, v := range files {
+		w, err := zw.Create("vim/" + k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(v))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestContext creates a context for a package in temporary dir.
+func newTestContext(t *testing.T, dir string, src Source) *context {
+	work := filepath.Join(dir, "target", "netupvim")
+	c := &context{
+		targetDir: filepath.Join(dir, "target"),
+		dataDir:   work,
+		logDir:    filepath.Join(work, "log"),
+		tmpDir:    filepath.Join(work, "tmp"),
+		varDir:    filepath.Join(work, "var", src.name()),
+		source:    src,
+		ctx:       stdctx.Background(),
+	}
+	if err := c.mkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestVerifyRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestContext(t, dir, &DirectSource{Name: "vim", Strip: 1})
+	zipName := filepath.Join(c.tmpDir, "vim.zip")
+	writeTestZip(t, zipName, map[string]string{
+		"vim.exe":         "exe",
+		"runtime/a b.vim": "vim script",
+		"runtime/doc.txt": "document",
+	})
+	if err := extract(c, zipName, &anchorInfo{time: time.Now()}); err != nil {
+		t.Fatalf("extract failed: %s", err)
+	}
+	c.cacheArchive(zipName)
+
+	ok, err := verify(c)
+	if err != nil || !ok {
+		t.Fatalf("verify should succeed: ok=%t err=%v", ok, err)
+	}
+
+	// damage the installation.
+	os.Remove(filepath.Join(c.targetDir, "runtime", "a b.vim"))
+	writeTestFile(t, filepath.Join(c.targetDir, "runtime", "doc.txt"), "broken")
+	writeTestFile(t, filepath.Join(c.targetDir, "mine.txt"), "user's file")
+	r, err := verifyFiles(c)
+	if err != nil {
+		t.Fatalf("verifyFiles failed: %s", err)
+	}
+	if len(r.missing) != 1 || len(r.modified) != 1 || len(r.untracked) != 1 {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+
+	if err := repair(c); err != nil {
+		t.Fatalf("repair failed: %s", err)
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "a b.vim"), "vim script")
+	checkTestFile(t, filepath.Join(c.targetDir, "runtime", "doc.txt"), "document")
+	checkTestFile(t, filepath.Join(c.targetDir, "mine.txt"), "user's file")
+	if ok, _ := verify(c); !ok {
+		t.Errorf("installation should be repaired")
+	}
+
+	// other release isn't downloaded when the archive isn't cached.
+	os.RemoveAll(c.archiveDir())
+	writeTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "broken")
+	if err := repair(c); err != errArchiveNotCached {
+		t.Fatalf("repair should fail without cached archive: %v", err)
+	}
+	checkTestFile(t, filepath.Join(c.targetDir, "vim.exe"), "broken")
+}
+
+func TestVerifyKeepsPendingTxn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, _ := setupPlanTest(t, dir)
+	journal := filepath.Join(c.txnDir(), txnJournalName)
+	writeTestFile(t, journal, "")
+
+	pack := SourcePack{arch.X86: c.source}
+	ok, err := Verify(stdctx.Background(), c.targetDir, c.dataDir, pack, Arch{Name: "x86"})
+	if err != nil || !ok {
+		t.Fatalf("Verify should succeed: %t %v", ok, err)
+	}
+	if _, err := os.Stat(journal); err != nil {
+		t.Errorf("pending transaction should be kept by verify: %v", err)
+	}
+}
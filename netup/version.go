@@ -0,0 +1,203 @@
+package netup
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var rxVersionNum = regexp.MustCompile(`\d+`)
+
+// parseVersion extracts numbers from a version string or a tag, like
+// "v8.1.1234-20190501" to [8 1 1234 20190501].
+func parseVersion(s string) []int {
+	var v []int
+	for _, m := range rxVersionNum.FindAllString(s, -1) {
+		n, err := strconv.Atoi(m)
+		if err != nil {
+			break
+		}
+		v = append(v, n)
+	}
+	return v
+}
+
+// compareVersion compares two versions.  Missing numbers are treated as
+// zero.
+func compareVersion(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+type versionCond struct {
+	op string
+	v  []int
+}
+
+func (vc versionCond) match(v []int) bool {
+	c := compareVersion(v, vc.v)
+	switch vc.op {
+	case ">=":
+		return c >= 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	case "<":
+		return c < 0
+	default:
+		return c == 0
+	}
+}
+
+// versionPin is a constraint for tags of releases.  It is an exact tag like
+// "v8.1.1234", or a range like ">=8.1 <8.2", "8.1.*", "~8.1.1234" or "^8".
+type versionPin struct {
+	exact string
+	conds []versionCond
+}
+
+func parseVersionPin(s string) (*versionPin, error) {
+	f := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	if len(f) == 0 {
+		return nil, fmt.Errorf("empty version")
+	}
+	if len(f) == 1 && !strings.ContainsAny(f[0], "<>=~^*") &&
+		!strings.HasSuffix(f[0], ".x") {
+		return &versionPin{exact: f[0]}, nil
+	}
+	p := &versionPin{}
+	for _, t := range f {
+		conds, err := parseVersionCond(t)
+		if err != nil {
+			return nil, err
+		}
+		p.conds = append(p.conds, conds...)
+	}
+	return p, nil
+}
+
+// ValidateVersion checks format of version to pin release.
+func ValidateVersion(s string) error {
+	_, err := parseVersionPin(s)
+	return err
+}
+
+func parseVersionCond(t string) ([]versionCond, error) {
+	for _, op := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if !strings.HasPrefix(t, op) {
+			continue
+		}
+		v := parseVersion(t[len(op):])
+		if len(v) == 0 {
+			return nil, fmt.Errorf("invalid version: %q", t)
+		}
+		switch op {
+		case "~":
+			// same major and minor: ~8.1.2 and ~8.1 mean <8.2, ~8 means <9
+			n := len(v)
+			if n > 2 {
+				n = len(v) - 1
+			}
+			return versionRange(v, n), nil
+		case "^":
+			// same major: ^8.1 means >=8.1 <9
+			return versionRange(v, 1), nil
+		}
+		return []versionCond{{op: op, v: v}}, nil
+	}
+	// wildcard: 8.1.* or 8.1.x means >=8.1 <8.2
+	if s := strings.TrimSuffix(strings.TrimSuffix(t, ".*"), ".x"); s != t {
+		v := parseVersion(s)
+		if len(v) == 0 {
+			return nil, fmt.Errorf("invalid version: %q", t)
+		}
+		return versionRange(v, len(v)), nil
+	}
+	v := parseVersion(t)
+	if len(v) == 0 {
+		return nil, fmt.Errorf("invalid version: %q", t)
+	}
+	return []versionCond{{op: "=", v: v}}, nil
+}
+
+// versionRange makes conditions >=v and <(first n numbers of v, then last
+// one is incremented).
+func versionRange(v []int, n int) []versionCond {
+	upper := make([]int, n)
+	copy(upper, v)
+	upper[n-1]++
+	return []versionCond{{op: ">=", v: v}, {op: "<", v: upper}}
+}
+
+// match checks a tag satisfies the pin.
+func (p *versionPin) match(tag string) bool {
+	if p.exact != "" {
+		return tag == p.exact ||
+			strings.TrimPrefix(tag, "v") == strings.TrimPrefix(p.exact, "v")
+	}
+	v := parseVersion(tag)
+	if len(v) == 0 {
+		return false
+	}
+	for _, c := range p.conds {
+		if !c.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// below checks a version is lower than the range of the pin, so that older
+// releases can't match.
+func (p *versionPin) below(v []int) bool {
+	if p.exact != "" || len(v) == 0 {
+		return false
+	}
+	for _, c := range p.conds {
+		n := compareVersion(v, c.v)
+		switch c.op {
+		case ">=", "=":
+			if n < 0 {
+				return true
+			}
+		case ">":
+			if n <= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *versionPin) String() string {
+	if p.exact != "" {
+		return p.exact
+	}
+	s := make([]string, len(p.conds))
+	for i, c := range p.conds {
+		n := make([]string, len(c.v))
+		for j, x := range c.v {
+			n[j] = strconv.Itoa(x)
+		}
+		s[i] = c.op + strings.Join(n, ".")
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,71 @@
+package netup
+
+import "testing"
+
+func TestVersionPin(t *testing.T) {
+	for _, tc := range []struct {
+		pin string
+		tag string
+		ok  bool
+	}{
+		{"v8.1.1234", "v8.1.1234", true},
+		{"8.1.1234", "v8.1.1234", true},
+		{"v8.1.1234", "v8.1.1235", false},
+		{"8.1.*", "v8.1.0001", true},
+		{"8.1.*", "v8.2.0001", false},
+		{"8.1.x", "v8.1.2000", true},
+		{">=8.1 <8.2", "v8.1.1234", true},
+		{">=8.1, <8.2", "v8.2.0000", false},
+		{"~8.1.1000", "v8.1.1234", true},
+		{"~8.1.1000", "v8.1.0999", false},
+		{"~8.1.1000", "v8.2.0000", false},
+		{"~8.1", "v8.1.2000", true},
+		{"~8.1", "v8.2.0000", false},
+		{"~8", "v8.2.0000", true},
+		{"^8.1", "v8.2.0000", true},
+		{"^8.1", "v9.0.0000", false},
+		{">8.1.1234", "v8.1.1234-20190501", true},
+		{"<=8.1", "v8.1.0000", true},
+		{">=8.1", "nightly", false},
+	} {
+		p, err := parseVersionPin(tc.pin)
+		if err != nil {
+			t.Errorf("parseVersionPin(%q) failed: %s", tc.pin, err)
+			continue
+		}
+		if got := p.match(tc.tag); got != tc.ok {
+			t.Errorf("pin %q match %q: expected %t but %t", tc.pin, tc.tag, tc.ok, got)
+		}
+	}
+}
+
+func TestVersionPinInvalid(t *testing.T) {
+	for _, s := range []string{"", ">=", "~foo", "*"} {
+		if _, err := parseVersionPin(s); err == nil {
+			t.Errorf("parseVersionPin(%q) should fail", s)
+		}
+	}
+}
+
+func TestVersionPinBelow(t *testing.T) {
+	for _, tc := range []struct {
+		pin   string
+		tag   string
+		below bool
+	}{
+		{">=8.1 <8.2", "v8.0.1000", true},
+		{">=8.1 <8.2", "v8.1.0000", false},
+		{">=8.1 <8.2", "v8.2.0000", false},
+		{">8.1.1234", "v8.1.1234", true},
+		{"<8.2", "v7.4", false},
+		{"v8.1.1234", "v7.4", false},
+	} {
+		p, err := parseVersionPin(tc.pin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.below(parseVersion(tc.tag)); got != tc.below {
+			t.Errorf("pin %q below %q: expected %t but %t", tc.pin, tc.tag, tc.below, got)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koron/netupvim/netup"
+)
+
+// runServe runs netupvim as a caching mirror server for LAN.
+func runServe(ctx context.Context, args []string) error {
+	conf, err := loadConfig("netupvim.ini")
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var (
+		targetOpt   = fs.String("t", conf.getTargetDir(), "dir to save cache under its \"netupvim\" dir")
+		addrOpt     = fs.String("addr", ":8080", "address to listen")
+		sourcesOpt  = fs.String("s", "", "comma separated sources to mirror (default: sources in config)")
+		intervalOpt = fs.Duration("interval", time.Hour, "interval to poll sources")
+	)
+	fs.Parse(args)
+	if *intervalOpt < time.Minute {
+		return fmt.Errorf("interval is too short: %s", *intervalOpt)
+	}
+	if err := applyConfig(conf); err != nil {
+		return err
+	}
+
+	names := mirrorSources(conf, *sourcesOpt)
+	packs := make(map[string]netup.SourcePack, len(names)+1)
+	for _, name := range names {
+		pack, ok := sources[name]
+		if !ok {
+			return fmt.Errorf("invalid source: %s", name)
+		}
+		if pinVersion != "" && name == conf.getSource() {
+			if err := pinPack(name, pack); err != nil {
+				return err
+			}
+		}
+		packs[name] = pack
+	}
+	if !conf.DisableSelfUpdate {
+		packs["netup"] = netupPack
+	}
+
+	workDir := filepath.Join(*targetOpt, "netupvim")
+	return netup.Serve(ctx, workDir, *addrOpt, packs, *intervalOpt)
+}
+
+// mirrorSources returns names of sources to mirror.  Default is the source
+// and sources of packages in config.
+func mirrorSources(conf *config, s string) []string {
+	if s != "" {
+		return strings.Split(s, ",")
+	}
+	names := []string{conf.getSource()}
+	for _, pc := range conf.Packages {
+		if pc.Source != "" {
+			names = append(names, pc.Source)
+		}
+	}
+	return names
+}